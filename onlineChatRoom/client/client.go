@@ -1,16 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"onlineChatRoom/client/tool"
-	"onlineChatRoom/utils"
+	"onlineChatRoom/config"
+	"os"
 )
 
-// 服务端是否关闭
-var serverErrFlag = make(chan struct{})
-
 // 客户是否退出通道
 var clientQuitFlag = make(chan struct{})
 
@@ -20,30 +20,23 @@ func main() {
 			log.Printf("client main panic recovered: %v\n", err)
 		}
 	}()
-	conn, err := net.Dial("tcp", "localhost:8080") //连接服务端
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn, err := dial(cfg.Client) //连接服务端
 	if err != nil {
 		log.Fatal("连接服务器出错...", err)
 	}
-	defer utils.CloseConn(conn, "客户端")
+	tool.DownloadDir = cfg.Client.DownloadDir
+	reader := bufio.NewReader(conn)
 	fmt.Println("-------------欢迎来到网络聊天室-------------")
-	userMsg := tool.HandleRegOrLog(conn)
-	//处理服务端的发来的信息
-	go func() {
-		err = tool.HandleServerMessage(conn, serverErrFlag)
-		if err != nil {
-			log.Println(err)
-		}
-		close(serverErrFlag)
-	}()
-	//发送心跳
-	go func() {
-		err = tool.StartHeartbeat(userMsg.Sender, conn)
-		if err != nil {
-			log.Println(err)
-		}
-		close(clientQuitFlag)
-	}()
+	userMsg := tool.HandleRegOrLog(conn, reader)
 	tool.Screen()
+	if err = tool.StartConsole(); err != nil {
+		log.Println(err)
+	}
+	defer tool.StopConsole()
 	//输入内容的管道
 	var msgChan = make(chan string)
 	// 键盘并发输入
@@ -57,16 +50,69 @@ func main() {
 			msgChan <- content
 		}
 	}()
-	// 消息发送
+	outbox := tool.NewOutbox(cfg.Client.Reconnect.OutboxSize)
 	for {
-		select {
-		case <-serverErrFlag: // 服务端崩溃
-			fmt.Println("与服务端断开连接，客户端退出...")
-			return
-		case <-clientQuitFlag: // 客户端异常
-			return
-		case content := <-msgChan:
+		//处理服务端的发来的信息并发送心跳，连接断开后 lost 关闭
+		lost := tool.Serve(conn, reader, userMsg.Sender, cfg.Heartbeat.Interval)
+		outbox.Flush(func(content string) {
 			tool.SendServer(content, conn, userMsg, clientQuitFlag)
+		})
+		// 消息发送
+	online:
+		for {
+			select {
+			case <-lost: // 服务端崩溃或网络断开
+				break online
+			case <-clientQuitFlag: // 客户端退出
+				return
+			case content := <-msgChan:
+				tool.SendServer(content, conn, userMsg, clientQuitFlag)
+			}
+		}
+		fmt.Println("[连接状态] 与服务端断开连接，正在重连...")
+		// 重连期间照常接收输入，暂存到重连成功
+		type result struct {
+			conn   net.Conn
+			reader *bufio.Reader
+			err    error
+		}
+		reconnected := make(chan result, 1)
+		go func() {
+			c, r, reconnectErr := tool.Reconnect(func() (net.Conn, error) { return dial(cfg.Client) }, userMsg, cfg.Client.Reconnect, clientQuitFlag)
+			reconnected <- result{c, r, reconnectErr}
+		}()
+	offline:
+		for {
+			select {
+			case res := <-reconnected:
+				if res.err != nil {
+					return
+				}
+				conn, reader = res.conn, res.reader
+				fmt.Println("[连接状态] 已重新连接")
+				tool.ResumeTransfers(conn)
+				break offline
+			case <-clientQuitFlag:
+				return
+			case content := <-msgChan:
+				if content == "quit" {
+					fmt.Println("退出成功...")
+					return
+				}
+				outbox.Add(content)
+			}
 		}
 	}
 }
+
+// dial 按配置连接服务端，开启 TLS 时使用 TLS 连接
+func dial(cfg config.Client) (net.Conn, error) {
+	if !cfg.TLS.Enabled {
+		return net.Dial("tcp", cfg.ServerAddr)
+	}
+	tlsCfg, err := cfg.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial("tcp", cfg.ServerAddr, tlsCfg)
+}
@@ -0,0 +1,93 @@
+package tool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// console 标准输入是终端时使用的行编辑器，逐键读取以便发送正在输入事件，提示符用作状态栏
+// 标准输入不是终端(如管道输入)时为 nil，仍按行读取
+var console *term.Terminal
+
+// consoleState 切换前的终端模式，退出时恢复
+var consoleState *term.State
+
+// prompt 没有状态时的提示符
+const prompt = "> "
+
+// consoleDone 输出全部写到终端后关闭
+var consoleDone chan struct{}
+
+// StartConsole 登录后切换到逐键读取，之后标准输出和日志都经过 console，不会打乱正在输入的内容
+func StartConsole() error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("切换终端模式失败:%w", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		_ = term.Restore(fd, state)
+		return fmt.Errorf("切换终端模式失败:%w", err)
+	}
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, prompt)
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if unicode.IsPrint(key) {
+			typist.keypress(line[:pos] + string(key) + line[pos:])
+		}
+		return "", 0, false
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reader := bufio.NewReader(r)
+		for {
+			line, readErr := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				_, _ = t.Write(line)
+			}
+			if readErr != nil {
+				return
+			}
+		}
+	}()
+	os.Stdout = w
+	log.SetOutput(w)
+	console, consoleState, consoleDone = t, state, done
+	return nil
+}
+
+// StopConsole 输出写完后恢复终端模式
+func StopConsole() {
+	if console == nil {
+		return
+	}
+	w := os.Stdout
+	os.Stdout = os.NewFile(uintptr(1), "/dev/stdout")
+	log.SetOutput(os.Stderr)
+	_ = w.Close()
+	<-consoleDone
+	_ = term.Restore(int(os.Stdin.Fd()), consoleState)
+}
+
+// setStatus 把状态显示在输入行前面
+func setStatus(status string) {
+	if console == nil {
+		return
+	}
+	console.SetPrompt(status)
+	// 不写内容也会重绘提示符和正在输入的内容
+	_, _ = console.Write(nil)
+}
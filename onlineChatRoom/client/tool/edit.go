@@ -0,0 +1,54 @@
+package tool
+
+import (
+	"fmt"
+	"onlineChatRoom/msg"
+	"strconv"
+	"strings"
+)
+
+// editTypes 修改已发送消息的命令
+var editTypes = map[string]msg.MessageType{
+	"edit":   msg.MessageEdit,
+	"recall": msg.MessageRecall,
+	"delete": msg.MessageDelete,
+}
+
+// editCommand 解析 edit 消息ID 新内容；recall 消息ID；delete 消息ID(管理员)
+// 第二个词不是消息ID时不当作命令，按普通聊天发送
+func editCommand(content string, sender string) (*msg.Message, bool, error) {
+	command, rest, _ := strings.Cut(content, " ")
+	messageType, ok := editTypes[command]
+	if !ok {
+		return nil, false, nil
+	}
+	idText, text, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil || id <= 0 {
+		return nil, false, nil
+	}
+	text = strings.TrimSpace(text)
+	if messageType == msg.MessageEdit && text == "" {
+		return nil, true, fmt.Errorf("格式错误，应为 edit 消息ID 新内容")
+	}
+	if messageType != msg.MessageEdit && text != "" {
+		return nil, true, fmt.Errorf("格式错误，应为 %s 消息ID", command)
+	}
+	return &msg.Message{Type: messageType, Sender: sender, ID: id, Content: text}, true, nil
+}
+
+// showUpdate 展示其他人对已发送消息的编辑、撤回或管理员的删除
+func showUpdate(message *msg.Message) {
+	target := fmt.Sprintf("私聊 #%d", message.ID)
+	if message.Room != "" {
+		target = fmt.Sprintf("[%s] 消息 #%d", message.Room, message.ID)
+	}
+	switch message.Type {
+	case msg.MessageEdit:
+		fmt.Printf("%s 编辑了%s: %s\n", message.Sender, target, message.Content)
+	case msg.MessageRecall:
+		fmt.Printf("%s 撤回了%s\n", message.Sender, target)
+	case msg.MessageDelete:
+		fmt.Printf("%s 发送的%s 已被管理员删除\n", message.Sender, target)
+	}
+}
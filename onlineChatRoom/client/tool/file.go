@@ -0,0 +1,285 @@
+package tool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"onlineChatRoom/msg"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DownloadDir 下载文件的保存目录
+var DownloadDir = "downloads"
+
+// upload 进行中的上传
+type upload struct {
+	sender   string
+	path     string
+	name     string
+	size     int64
+	checksum string
+	room     string
+	receiver string
+	progress int64 // 已展示的进度，百分比
+}
+
+// download 进行中的下载
+type download struct {
+	sender   string
+	fileID   int64
+	progress int64
+}
+
+// transferState 进行中的上传和下载，以发送序号区分，服务端的回复原样带回
+type transferState struct {
+	mu        sync.Mutex
+	uploads   map[int64]*upload
+	downloads map[int64]*download
+}
+
+var transfers = &transferState{uploads: make(map[int64]*upload), downloads: make(map[int64]*download)}
+
+// finish 收到 ack/nack 后结束对应的传输
+func (ts *transferState) finish(seq int64) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	delete(ts.uploads, seq)
+	delete(ts.downloads, seq)
+}
+
+// fileCommand 解析 send-file 路径 [@用户名] 和 download 文件ID
+func fileCommand(content string) (command string, arg string, ok bool) {
+	for _, command = range []string{"send-file", "download"} {
+		if arg, ok = strings.CutPrefix(content, command+" "); ok {
+			return command, strings.TrimSpace(arg), true
+		}
+	}
+	return "", "", false
+}
+
+// SendFile 开始上传文件，receiver 为空时发到 room
+func SendFile(conn net.Conn, sender string, path string, room string, receiver string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Println("读取文件失败:", err)
+		return
+	}
+	if info.IsDir() {
+		fmt.Println("不能发送目录:", path)
+		return
+	}
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		fmt.Println("读取文件失败:", err)
+		return
+	}
+	u := &upload{
+		sender:   sender,
+		path:     path,
+		name:     filepath.Base(path),
+		size:     info.Size(),
+		checksum: checksum,
+		room:     room,
+		receiver: receiver,
+	}
+	seq := pending.add("[文件] " + u.name)
+	transfers.mu.Lock()
+	transfers.uploads[seq] = u
+	transfers.mu.Unlock()
+	startUpload(conn, seq, u)
+}
+
+// startUpload 请求开始上传，服务端回复已收到的字节数，中断过的上传从那里继续
+func startUpload(conn net.Conn, seq int64, u *upload) {
+	err := msg.SendJsonMessage(conn, &msg.Message{
+		Type:     msg.MessageFileUpload,
+		Seq:      seq,
+		Sender:   u.sender,
+		Room:     u.room,
+		Receiver: u.receiver,
+		Content:  u.name,
+		Size:     u.size,
+		Checksum: u.checksum,
+	})
+	if err != nil {
+		log.Println("send msg.MessageFileUpload failed...", err)
+	}
+}
+
+// sendNextChunk 收到服务端已收到的字节数后发送下一块
+func sendNextChunk(conn net.Conn, message *msg.Message) {
+	transfers.mu.Lock()
+	u, ok := transfers.uploads[message.Seq]
+	transfers.mu.Unlock()
+	if !ok || message.Offset >= u.size {
+		return
+	}
+	showProgress("上传", u.name, &u.progress, message.Offset, u.size)
+	f, err := os.Open(u.path)
+	if err != nil {
+		fmt.Println("读取文件失败:", err)
+		return
+	}
+	defer f.Close()
+	buf := make([]byte, min(message.Size, u.size-message.Offset))
+	if _, err = f.ReadAt(buf, message.Offset); err != nil {
+		fmt.Println("读取文件失败:", err)
+		return
+	}
+	err = msg.SendJsonMessage(conn, &msg.Message{
+		Type:   msg.MessageFileChunk,
+		Seq:    message.Seq,
+		Sender: u.sender,
+		FileID: message.FileID,
+		Offset: message.Offset,
+		Data:   buf,
+	})
+	if err != nil {
+		log.Println("send msg.MessageFileChunk failed...", err)
+	}
+}
+
+// Download 开始下载，之前下载到一半的从断点继续
+func Download(conn net.Conn, sender string, id string) {
+	fileID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || fileID <= 0 {
+		fmt.Println("格式错误，应为 download 文件ID")
+		return
+	}
+	d := &download{sender: sender, fileID: fileID}
+	seq := pending.add(fmt.Sprintf("下载文件 %d", fileID))
+	transfers.mu.Lock()
+	transfers.downloads[seq] = d
+	transfers.mu.Unlock()
+	requestChunk(conn, seq, d)
+}
+
+// partPath 下载中的临时文件
+func partPath(fileID int64) string {
+	return filepath.Join(DownloadDir, strconv.FormatInt(fileID, 10)+".part")
+}
+
+// requestChunk 请求临时文件末尾之后的一块
+func requestChunk(conn net.Conn, seq int64, d *download) {
+	var offset int64
+	if info, err := os.Stat(partPath(d.fileID)); err == nil {
+		offset = info.Size()
+	}
+	err := msg.SendJsonMessage(conn, &msg.Message{Type: msg.MessageFileDownload, Seq: seq, Sender: d.sender, FileID: d.fileID, Offset: offset})
+	if err != nil {
+		log.Println("send msg.MessageFileDownload failed...", err)
+	}
+}
+
+// receiveChunk 写入收到的一块，没收齐就请求下一块，收齐后校验
+func receiveChunk(conn net.Conn, message *msg.Message) {
+	transfers.mu.Lock()
+	d, ok := transfers.downloads[message.Seq]
+	transfers.mu.Unlock()
+	if !ok {
+		return
+	}
+	received, err := writeChunk(message)
+	if err != nil {
+		transfers.finish(message.Seq)
+		pending.done(message.Seq)
+		fmt.Println("保存文件失败:", err)
+		return
+	}
+	showProgress("下载", message.Content, &d.progress, received, message.Size)
+	if received < message.Size {
+		requestChunk(conn, message.Seq, d)
+		return
+	}
+	transfers.finish(message.Seq)
+	pending.done(message.Seq)
+	path, err := completeDownload(message)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("文件 %s 下载完成，保存在 %s\n", message.Content, path)
+}
+
+// writeChunk 把一块写到临时文件的对应位置，返回已收到的字节数
+func writeChunk(message *msg.Message) (int64, error) {
+	if err := os.MkdirAll(DownloadDir, 0o755); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(partPath(message.FileID), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err = f.WriteAt(message.Data, message.Offset); err != nil {
+		return 0, err
+	}
+	received := message.Offset + int64(len(message.Data))
+	return received, f.Truncate(received)
+}
+
+// completeDownload 校验通过后改为原文件名，同名文件已存在时加上文件ID
+func completeDownload(message *msg.Message) (string, error) {
+	part := partPath(message.FileID)
+	checksum, err := fileChecksum(part)
+	if err != nil {
+		return "", fmt.Errorf("读取文件失败:%w", err)
+	}
+	if checksum != message.Checksum {
+		_ = os.Remove(part)
+		return "", fmt.Errorf("文件 %s 校验失败，请重新下载", message.Content)
+	}
+	name := filepath.Base(message.Content)
+	path := filepath.Join(DownloadDir, name)
+	if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		path = filepath.Join(DownloadDir, fmt.Sprintf("%d_%s", message.FileID, name))
+	}
+	if err = os.Rename(part, path); err != nil {
+		return "", fmt.Errorf("保存文件失败:%w", err)
+	}
+	return path, nil
+}
+
+// ResumeTransfers 重连后继续未完成的上传和下载
+func ResumeTransfers(conn net.Conn) {
+	transfers.mu.Lock()
+	defer transfers.mu.Unlock()
+	for seq, u := range transfers.uploads {
+		startUpload(conn, seq, u)
+	}
+	for seq, d := range transfers.downloads {
+		requestChunk(conn, seq, d)
+	}
+}
+
+// showProgress 每完成 10% 展示一次进度
+func showProgress(action string, name string, shown *int64, done int64, total int64) {
+	percent := done * 100 / total
+	if percent/10 <= *shown/10 && done < total {
+		return
+	}
+	*shown = percent
+	fmt.Printf("%s %s: %d%% (%d/%d)\n", action, name, percent, done, total)
+}
+
+// fileChecksum 计算文件的 sha256
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,79 @@
+package tool
+
+import (
+	"fmt"
+	"onlineChatRoom/msg"
+	"strconv"
+	"strings"
+)
+
+// historyCommand 解析 history [@用户名] [条数] [before 消息ID]，不带 @用户名 时查询当前发言房间
+// 后面的内容不符合格式时不当作命令，按普通聊天发送
+func historyCommand(content string, sender string) (*msg.Message, bool, error) {
+	fields := strings.Fields(content)
+	if len(fields) == 0 || fields[0] != "history" {
+		return nil, false, nil
+	}
+	message := &msg.Message{Type: msg.MessageHistory, Sender: sender}
+	rest := fields[1:]
+	if len(rest) > 0 && strings.HasPrefix(rest[0], "@") {
+		message.Receiver = rest[0][1:]
+		if message.Receiver == "" {
+			return nil, false, nil
+		}
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && rest[0] != "before" {
+		n, err := strconv.ParseInt(rest[0], 10, 64)
+		if err != nil || n <= 0 {
+			return nil, false, nil
+		}
+		message.Limit = n
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		if len(rest) != 2 || rest[0] != "before" {
+			return nil, false, nil
+		}
+		id, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil || id <= 0 {
+			return nil, false, nil
+		}
+		message.ID = id
+	}
+	if message.Receiver == "" {
+		if message.Room = rooms.Current(); message.Room == "" {
+			return nil, true, fmt.Errorf("当前没有加入任何房间，请先 join 房间名")
+		}
+	}
+	return message, true, nil
+}
+
+// showHistory 展示一页历史消息，满一页时提示如何查看更早的
+func showHistory(message *msg.Message) {
+	target := "[" + message.Room + "] 的历史消息"
+	if message.Receiver != "" {
+		target = "与 " + message.Receiver + " 的私聊记录"
+	}
+	if len(message.Messages) == 0 {
+		if message.ID != 0 {
+			fmt.Printf("%s：没有更早的消息了\n", target)
+		} else {
+			fmt.Printf("%s：暂无消息\n", target)
+		}
+		return
+	}
+	fmt.Printf("---- %s ----\n", target)
+	for _, m := range message.Messages {
+		fmt.Println(formatArchived(m))
+	}
+	if int64(len(message.Messages)) < message.Limit {
+		fmt.Println("----")
+		return
+	}
+	command := "history"
+	if message.Receiver != "" {
+		command += " @" + message.Receiver
+	}
+	fmt.Printf("---- 输入 %s %d before %d 查看更早的消息 ----\n", command, message.Limit, message.Messages[0].ID)
+}
@@ -0,0 +1,24 @@
+package tool
+
+import (
+	"fmt"
+	"onlineChatRoom/msg"
+)
+
+// highlight 被 @ 的消息以黄色加粗展示
+const highlight = "\033[1;33m%s\033[0m\n"
+
+// showMention 展示被 @ 的提醒并响铃；带消息列表的是登录时展示的离线期间的提醒
+func showMention(message *msg.Message) {
+	fmt.Print("\a")
+	if len(message.Messages) > 0 {
+		fmt.Printf(highlight, fmt.Sprintf("---- 你不在线时有 %d 条消息提到了你 ----", len(message.Messages)))
+		for _, m := range message.Messages {
+			fmt.Printf(highlight, formatArchived(m))
+		}
+		fmt.Println("----")
+		return
+	}
+	showQuote(message)
+	fmt.Printf(highlight, prefix(message)+"[有人@你] "+message.Content)
+}
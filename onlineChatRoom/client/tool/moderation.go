@@ -0,0 +1,50 @@
+package tool
+
+import (
+	"fmt"
+	"onlineChatRoom/msg"
+	"strings"
+	"time"
+)
+
+// moderationTypes 管理员命令
+var moderationTypes = map[string]msg.MessageType{
+	"kick":   msg.MessageKick,
+	"mute":   msg.MessageMute,
+	"ban":    msg.MessageBan,
+	"unmute": msg.MessageUnmute,
+	"unban":  msg.MessageUnban,
+}
+
+// moderationCommand 解析管理员命令
+// kick 用户名 [原因]；mute/ban 用户名 [时长] [原因]，时长如 10m、2h，不填为永久；unmute/unban 用户名
+func moderationCommand(content string, sender string) (*msg.Message, bool, error) {
+	fields := strings.Fields(content)
+	if len(fields) == 0 {
+		return nil, false, nil
+	}
+	messageType, ok := moderationTypes[fields[0]]
+	if !ok {
+		return nil, false, nil
+	}
+	if len(fields) < 2 {
+		return nil, true, fmt.Errorf("格式错误，应为 %s 用户名", fields[0])
+	}
+	message := &msg.Message{Type: messageType, Sender: sender, Receiver: fields[1]}
+	rest := fields[2:]
+	if messageType == msg.MessageMute || messageType == msg.MessageBan {
+		if len(rest) > 0 {
+			if d, err := time.ParseDuration(rest[0]); err == nil {
+				if d < time.Second {
+					return nil, true, fmt.Errorf("时长不能小于1秒")
+				}
+				message.Duration = int64(d / time.Second)
+				rest = rest[1:]
+			}
+		}
+	}
+	if messageType != msg.MessageUnmute && messageType != msg.MessageUnban {
+		message.Content = strings.Join(rest, " ")
+	}
+	return message, true, nil
+}
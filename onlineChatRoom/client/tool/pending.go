@@ -0,0 +1,78 @@
+package tool
+
+import (
+	"fmt"
+	"net"
+	"onlineChatRoom/msg"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// pendingSends 已发出、等待服务端 ack/nack 的消息
+type pendingSends struct {
+	mu    sync.Mutex
+	seq   int64
+	items map[int64]string // 发送序号 -> 内容摘要
+}
+
+var pending = &pendingSends{items: make(map[int64]string)}
+
+// add 分配发送序号并记录
+func (p *pendingSends) add(summary string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	p.items[p.seq] = summary
+	return p.seq
+}
+
+// done 收到 ack/nack 后移除
+func (p *pendingSends) done(seq int64) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	summary := p.items[seq]
+	delete(p.items, seq)
+	return summary
+}
+
+// sendTracked 发送需要服务端确认的消息，状态在收到 ack/nack 时展示
+func sendTracked(conn net.Conn, message *msg.Message) {
+	message.Seq = pending.add(excerpt(message.Content, 20))
+	err := msg.SendJsonMessage(conn, message)
+	if err != nil {
+		pending.done(message.Seq)
+		fmt.Println("发送失败:", err)
+	}
+}
+
+// showAck 展示服务端的确认结果
+func showAck(message *msg.Message) {
+	summary := pending.done(message.Seq)
+	if message.Type == msg.MessageAck {
+		fmt.Printf("发送成功 #%d「%s」\n", message.ID, summary)
+		return
+	}
+	if summary == "" {
+		// list、rank 等不带发送序号的请求被拒绝
+		fmt.Println("请求失败:", message.Content)
+		return
+	}
+	fmt.Printf("发送失败「%s」: %s\n", summary, message.Content)
+}
+
+// prefix 消息ID和服务端时间，没有ID的消息(如系统提示)不加前缀
+func prefix(message *msg.Message) string {
+	if message.ID == 0 {
+		return ""
+	}
+	return fmt.Sprintf("#%d %s ", message.ID, time.UnixMilli(message.Time).Format("15:04:05"))
+}
+
+// excerpt 截取内容摘要
+func excerpt(content string, n int) string {
+	if utf8.RuneCountInString(content) <= n {
+		return content
+	}
+	return string([]rune(content)[:n]) + "..."
+}
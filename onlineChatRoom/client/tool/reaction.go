@@ -0,0 +1,55 @@
+package tool
+
+import (
+	"fmt"
+	"onlineChatRoom/db"
+	"onlineChatRoom/msg"
+	"strconv"
+	"strings"
+)
+
+// reactionCommand 解析 react 消息ID 表情；unreact 消息ID 表情
+// 第二个词不是消息ID时不当作命令，按普通聊天发送
+func reactionCommand(content string, sender string) (*msg.Message, bool, error) {
+	fields := strings.Fields(content)
+	if len(fields) < 2 || (fields[0] != "react" && fields[0] != "unreact") {
+		return nil, false, nil
+	}
+	id, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || id <= 0 {
+		return nil, false, nil
+	}
+	if len(fields) != 3 {
+		return nil, true, fmt.Errorf("格式错误，应为 %s 消息ID 表情", fields[0])
+	}
+	messageType := msg.MessageReact
+	if fields[0] == "unreact" {
+		messageType = msg.MessageUnreact
+	}
+	return &msg.Message{Type: messageType, Sender: sender, ID: id, Content: fields[2]}, true, nil
+}
+
+// showReaction 展示表情回应及该消息汇总后的全部回应
+func showReaction(message *msg.Message) {
+	target := fmt.Sprintf("私聊 #%d", message.ID)
+	if message.Room != "" {
+		target = fmt.Sprintf("[%s] 消息 #%d", message.Room, message.ID)
+	}
+	verb := "回应了"
+	if message.Type == msg.MessageUnreact {
+		verb = "取消了回应"
+	}
+	fmt.Printf("%s 对%s %s %s  [%s]\n", message.Sender, target, verb, message.Content, reactionText(message.Reactions))
+}
+
+// reactionText 汇总的回应，如 "👍×2 ❤️×1"
+func reactionText(reactions []db.Reaction) string {
+	if len(reactions) == 0 {
+		return "暂无回应"
+	}
+	parts := make([]string, 0, len(reactions))
+	for _, r := range reactions {
+		parts = append(parts, fmt.Sprintf("%s×%d", r.Emoji, len(r.Users)))
+	}
+	return strings.Join(parts, " ")
+}
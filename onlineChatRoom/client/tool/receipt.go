@@ -0,0 +1,60 @@
+package tool
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"onlineChatRoom/msg"
+	"time"
+)
+
+// receiptCommand 解析 receipts [on|off]
+func receiptCommand(content string, sender string) (*msg.Message, bool) {
+	switch content {
+	case "receipts":
+		return &msg.Message{Type: msg.MessageReceipts, Sender: sender}, true
+	case "receipts on", "receipts off":
+		return &msg.Message{Type: msg.MessageReceipts, Sender: sender, Content: content[len("receipts "):]}, true
+	}
+	return nil, false
+}
+
+// reportRead 私聊展示后告知服务端已读，服务端按自己的设置决定是否转告发送者
+func reportRead(conn net.Conn, message *msg.Message) {
+	if message.ID == 0 {
+		return
+	}
+	err := msg.SendJsonMessage(conn, &msg.Message{Type: msg.MessageRead, Sender: session.name(), ID: message.ID})
+	if err != nil {
+		log.Println("send msg.MessageRead failed...", err)
+	}
+}
+
+// showReceipt 展示已读回执
+func showReceipt(message *msg.Message) {
+	fmt.Printf("[已读] %s 在 %s 读了你的私聊 #%d\n", message.Sender, time.UnixMilli(message.Time).Format("15:04:05"), message.ID)
+}
+
+// showReceipts 展示最近发出的私聊的送达和已读状态
+func showReceipts(message *msg.Message) {
+	if len(message.Messages) == 0 {
+		fmt.Println("最近没有发出过私聊")
+		return
+	}
+	fmt.Printf("---- 最近发出的 %d 条私聊 ----\n", len(message.Messages))
+	for _, m := range message.Messages {
+		fmt.Println(formatArchived(m), " ", receiptStatus(m))
+	}
+	fmt.Println("----")
+}
+
+// receiptStatus 送达和已读状态，如 "已读 15:04:05"
+func receiptStatus(m *msg.Message) string {
+	switch {
+	case m.ReadAt != 0:
+		return "已读 " + time.UnixMilli(m.ReadAt).Format("01-02 15:04:05")
+	case m.DeliveredAt != 0:
+		return "已送达 " + time.UnixMilli(m.DeliveredAt).Format("01-02 15:04:05")
+	}
+	return "未送达"
+}
@@ -0,0 +1,139 @@
+package tool
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"onlineChatRoom/config"
+	"onlineChatRoom/msg"
+	"onlineChatRoom/utils"
+	"sync"
+	"time"
+)
+
+// Serve 启动读取服务端消息和发送心跳的协程，连接断开后关闭连接并关闭返回的通道
+func Serve(conn net.Conn, reader *bufio.Reader, username string, interval time.Duration) <-chan struct{} {
+	lost := make(chan struct{})
+	var once sync.Once
+	disconnect := func() {
+		once.Do(func() {
+			utils.CloseConn(conn, "客户端")
+			close(lost)
+		})
+	}
+	go func() {
+		_ = HandleServerMessage(conn, reader)
+		disconnect()
+	}()
+	typist.attach(conn, username)
+	go watchTyping(lost)
+	go func() {
+		// 心跳发不出去说明连接已断开，读协程随之退出
+		if err := StartHeartbeat(username, conn, interval, lost); err != nil {
+			disconnect()
+		}
+	}()
+	return lost
+}
+
+// backoff 指数退避，每失败一次等待时间翻倍，并在 [d/2, d) 之间随机抖动，避免服务端重启后所有客户端同时重连
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt int
+}
+
+// next 下一次重连前的等待时间
+func (b *backoff) next() time.Duration {
+	d := b.max
+	if b.attempt < 32 && b.min<<b.attempt < b.max {
+		d = b.min << b.attempt
+	}
+	b.attempt++
+	return d/2 + rand.N(d/2+1)
+}
+
+// Reconnect 断线后按指数退避重连，直到成功或 quit 关闭
+// 连接上后先凭会话令牌恢复，会话已失效时用 userMsg 中登录时的账号密码重新登录
+func Reconnect(dial func() (net.Conn, error), userMsg *msg.Message, cfg config.Reconnect, quit <-chan struct{}) (net.Conn, *bufio.Reader, error) {
+	b := &backoff{min: cfg.MinDelay, max: cfg.MaxDelay}
+	for {
+		delay := b.next()
+		fmt.Printf("[连接状态] 第 %d 次重连，%s 后开始...\n", b.attempt, delay.Round(100*time.Millisecond))
+		select {
+		case <-quit:
+			return nil, nil, errors.New("已退出")
+		case <-time.After(delay):
+		}
+		conn, err := dial()
+		if err != nil {
+			fmt.Println("[连接状态] 连接服务端失败:", err)
+			continue
+		}
+		reader := bufio.NewReader(conn)
+		_, err = ResumeSession(conn, reader)
+		if errors.Is(err, ErrNoSession) {
+			err = relogin(conn, reader, userMsg)
+		}
+		if err != nil {
+			fmt.Println("[连接状态]", err)
+			utils.CloseConn(conn, "客户端")
+			continue
+		}
+		return conn, reader, nil
+	}
+}
+
+// relogin 会话已失效时用账号密码重新登录
+func relogin(conn net.Conn, reader *bufio.Reader, userMsg *msg.Message) error {
+	err := msg.SendJsonMessage(conn, &msg.Message{Type: msg.MessageJoin, Sender: userMsg.Sender, Content: userMsg.Content})
+	if err != nil {
+		return fmt.Errorf("发送登录请求失败:%w", err)
+	}
+	response, err := msg.ReadJsonMessage(reader)
+	if err != nil {
+		return fmt.Errorf("读取登录结果失败:%w", err)
+	}
+	if response.Content != "OK" {
+		return fmt.Errorf("重新登录失败: %s", response.Content)
+	}
+	session.login(userMsg.Sender, response.Token)
+	// 重新登录后服务端只把用户放进默认房间
+	rooms.reset()
+	return nil
+}
+
+// Outbox 断线期间输入的内容，重连后依次发送
+type Outbox struct {
+	limit int
+	items []string
+}
+
+func NewOutbox(limit int) *Outbox {
+	return &Outbox{limit: limit}
+}
+
+// Add 暂存一条输入，已满时丢弃并提示
+func (o *Outbox) Add(content string) {
+	if len(o.items) >= o.limit {
+		fmt.Println("[连接状态] 离线暂存已满，这条消息没有保存:", excerpt(content, 20))
+		return
+	}
+	o.items = append(o.items, content)
+	fmt.Printf("[连接状态] 正在重连，消息已暂存(%d/%d)，连接后自动发送\n", len(o.items), o.limit)
+}
+
+// Flush 依次交给 send 发送并清空
+func (o *Outbox) Flush(send func(content string)) {
+	if len(o.items) == 0 {
+		return
+	}
+	fmt.Printf("[连接状态] 发送离线期间暂存的 %d 条消息\n", len(o.items))
+	items := o.items
+	o.items = nil
+	for _, content := range items {
+		send(content)
+	}
+}
@@ -0,0 +1,79 @@
+package tool
+
+import (
+	"onlineChatRoom/msg"
+	"sync"
+)
+
+// roomState 客户端已加入的房间和当前发言房间
+type roomState struct {
+	mu      sync.Mutex
+	current string
+	joined  map[string]bool
+}
+
+// rooms 登录后默认在 msg.DefaultRoom
+var rooms = &roomState{
+	current: msg.DefaultRoom,
+	joined:  map[string]bool{msg.DefaultRoom: true},
+}
+
+// Current 当前发言房间，未加入任何房间时为空
+func (rs *roomState) Current() string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.current
+}
+
+// join 加入房间并切换为当前发言房间
+func (rs *roomState) join(name string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.joined[name] = true
+	rs.current = name
+}
+
+// leave 离开房间，若离开的是当前房间则切换到任意一个已加入的房间
+func (rs *roomState) leave(name string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.joined, name)
+	if rs.current != name {
+		return
+	}
+	rs.current = ""
+	if rs.joined[msg.DefaultRoom] {
+		rs.current = msg.DefaultRoom
+		return
+	}
+	for joined := range rs.joined {
+		rs.current = joined
+		return
+	}
+}
+
+// switchTo 切换当前发言房间，只能切换到已加入的房间
+func (rs *roomState) switchTo(name string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if !rs.joined[name] {
+		return false
+	}
+	rs.current = name
+	return true
+}
+
+// has 是否已加入房间
+func (rs *roomState) has(name string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.joined[name]
+}
+
+// reset 回到登录时的状态，只在默认房间
+func (rs *roomState) reset() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.current = msg.DefaultRoom
+	rs.joined = map[string]bool{msg.DefaultRoom: true}
+}
@@ -0,0 +1,24 @@
+package tool
+
+import (
+	"fmt"
+	"onlineChatRoom/msg"
+	"strings"
+)
+
+// searchCommand 解析 search 关键词... [过滤条件]，条件由服务端解析
+func searchCommand(content string, sender string) (*msg.Message, bool) {
+	if content != "search" && !strings.HasPrefix(content, "search ") {
+		return nil, false
+	}
+	return &msg.Message{Type: msg.MessageSearch, Sender: sender, Content: strings.TrimSpace(content[len("search"):])}, true
+}
+
+// showSearch 展示一页搜索结果，最新的在前
+func showSearch(message *msg.Message) {
+	fmt.Printf("---- 搜索结果：%s ----\n", message.Content)
+	for _, m := range message.Messages {
+		fmt.Println(formatArchived(m))
+	}
+	fmt.Println("----")
+}
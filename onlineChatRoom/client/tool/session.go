@@ -0,0 +1,88 @@
+package tool
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"onlineChatRoom/msg"
+	"sync"
+)
+
+// seenLimit 记住最近收到的消息ID个数，用于丢弃恢复会话时重复补发的消息
+const seenLimit = 1024
+
+// ErrNoSession 没有可以恢复的会话：还没有登录过，或会话已失效，只能重新输入密码登录
+var ErrNoSession = errors.New("no session")
+
+// sessionState 登录会话，断线后凭令牌和最后收到的消息ID恢复
+type sessionState struct {
+	mu       sync.Mutex
+	username string
+	token    string
+	lastSeen int64              // 收到过的最大消息ID
+	seen     map[int64]struct{} // 最近收到的消息ID
+	order    []int64            // seen 中的ID按收到的先后排列，超出上限时淘汰最早的
+}
+
+var session = &sessionState{seen: make(map[int64]struct{})}
+
+// login 登录成功后记下服务端下发的令牌
+func (s *sessionState) login(username string, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username = username
+	s.token = token
+}
+
+// name 登录的用户名
+func (s *sessionState) name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.username
+}
+
+// observe 记录收到的消息ID，已经收到过返回 false
+func (s *sessionState) observe(id int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.seen[id]; ok {
+		return false
+	}
+	s.seen[id] = struct{}{}
+	s.order = append(s.order, id)
+	if len(s.order) > seenLimit {
+		delete(s.seen, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.lastSeen = max(s.lastSeen, id)
+	return true
+}
+
+// ResumeSession 凭会话令牌免密恢复登录，成功返回和登录时一样的用户消息
+// reader 之后要继续用来读取服务端的消息，恢复成功后服务端紧接着补发错过的消息，不能丢
+func ResumeSession(conn net.Conn, reader *bufio.Reader) (*msg.Message, error) {
+	session.mu.Lock()
+	username, token, lastSeen := session.username, session.token, session.lastSeen
+	session.mu.Unlock()
+	if token == "" {
+		return nil, ErrNoSession
+	}
+	err := msg.SendJsonMessage(conn, &msg.Message{Type: msg.MessageResume, Sender: username, Token: token, ID: lastSeen})
+	if err != nil {
+		return nil, fmt.Errorf("发送恢复会话请求失败:%w", err)
+	}
+	response, err := msg.ReadJsonMessage(reader)
+	if err != nil {
+		return nil, fmt.Errorf("读取恢复会话结果失败:%w", err)
+	}
+	if response.Code == msg.CodeSessionExpired {
+		session.login(username, "")
+		return nil, ErrNoSession
+	}
+	// 其他失败(如服务端还没发现旧连接已断开)可以稍后重试
+	if response.Type != msg.MessageResume || response.Content != "OK" {
+		return nil, fmt.Errorf("恢复会话失败: %s", response.Content)
+	}
+	return &msg.Message{Type: msg.MessageJoin, Sender: username}, nil
+}
@@ -0,0 +1,74 @@
+package tool
+
+import (
+	"fmt"
+	"onlineChatRoom/msg"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// threadCommand 解析 reply 消息ID 内容；thread 消息ID
+// 第二个词不是消息ID时不当作命令，按普通聊天发送
+func threadCommand(content string, sender string) (*msg.Message, bool, error) {
+	command, rest, _ := strings.Cut(content, " ")
+	if command != "reply" && command != "thread" {
+		return nil, false, nil
+	}
+	idText, text, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil || id <= 0 {
+		return nil, false, nil
+	}
+	text = strings.TrimSpace(text)
+	if command == "thread" {
+		return &msg.Message{Type: msg.MessageThread, Sender: sender, ID: id}, true, nil
+	}
+	if text == "" {
+		return nil, true, fmt.Errorf("格式错误，应为 reply 消息ID 内容")
+	}
+	// 回复由服务端发到被回复的消息所在的房间或私聊
+	return &msg.Message{Type: msg.MessageChat, Sender: sender, Room: rooms.Current(), ParentID: id, Content: text}, true, nil
+}
+
+// showQuote 回复的消息先展示引用的摘要
+func showQuote(message *msg.Message) {
+	if message.ParentID == 0 {
+		return
+	}
+	if message.Quote == "" {
+		fmt.Printf("  ┌ 回复 #%d\n", message.ParentID)
+		return
+	}
+	fmt.Printf("  ┌ 回复 #%d %s\n", message.ParentID, message.Quote)
+}
+
+// showThread 展示整个话题
+func showThread(message *msg.Message) {
+	fmt.Printf("---- 话题 #%d，共 %d 条 ----\n", message.ID, len(message.Messages))
+	for _, m := range message.Messages {
+		fmt.Println(formatArchived(m))
+	}
+	fmt.Println("----")
+}
+
+// formatArchived 查询结果中的一条消息，如 "#12 10-18 15:04:05 [lobby] alice: 内容 (回复 #10) [👍×2]"
+func formatArchived(m *msg.Message) string {
+	line := fmt.Sprintf("#%d %s ", m.ID, time.UnixMilli(m.Time).Format("01-02 15:04:05"))
+	if m.Receiver != "" {
+		line += fmt.Sprintf("%s→%s: %s", m.Sender, m.Receiver, m.Content)
+	} else {
+		line += fmt.Sprintf("[%s] %s: %s", m.Room, m.Sender, m.Content)
+	}
+	if m.ParentID != 0 {
+		line += fmt.Sprintf(" (回复 #%d", m.ParentID)
+		if m.Quote != "" {
+			line += " " + m.Quote
+		}
+		line += ")"
+	}
+	if len(m.Reactions) > 0 {
+		line += "  [" + reactionText(m.Reactions) + "]"
+	}
+	return line
+}
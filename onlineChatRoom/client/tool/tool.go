@@ -18,12 +18,32 @@ func Screen() {
 	fmt.Println("2、输入：quit 退出...")
 	fmt.Println("3、输入：To:+用户名-->+内容 私聊...")
 	fmt.Println("4、输入：rank 查看聊天室所有用户活跃度排名...")
+	fmt.Println("5、输入：rooms 查看房间列表...")
+	fmt.Println("6、输入：create 房间名 创建房间...")
+	fmt.Println("7、输入：join 房间名 加入房间...")
+	fmt.Println("8、输入：leave 房间名 离开房间...")
+	fmt.Println("9、输入：switch 房间名 切换发言房间...")
+	fmt.Println("10、输入：send-file 文件路径 [@用户名] 发送文件到当前房间或私发给用户，download 文件ID 下载文件...")
+	fmt.Println("11、输入：edit 消息ID 新内容 编辑，recall 消息ID 撤回自己刚发送的消息...")
+	fmt.Println("12、输入：react 消息ID 表情 回应消息，unreact 消息ID 表情 取消回应...")
+	fmt.Println("13、输入：reply 消息ID 内容 回复消息，thread 消息ID 查看整个话题...")
+	fmt.Println("14、群聊中输入 @用户名 提醒对方，对方不在线时会在下次登录时看到...")
+	fmt.Println("15、输入：receipts 查看最近发出的私聊是否已读，receipts on/off 开启或关闭已读回执...")
+	fmt.Println("16、输入：search 关键词 [from:发送者] [in:房间 或 with:私聊对象] [since:2006-01-02] [until:2006-01-02] [page:页码] 搜索聊天记录...")
+	fmt.Println("17、输入：history [条数] [before 消息ID] 查看当前房间更早的消息，history @用户名 [条数] [before 消息ID] 查看和该用户的私聊记录...")
+	fmt.Println("18、管理员输入：kick 用户名 [原因] 踢人，mute/ban 用户名 [时长如10m] [原因] 禁言/封禁，unmute/unban 用户名 解除，delete 消息ID 删除消息...")
 }
 
 // KeyboardInput 键盘输入处理
 func KeyboardInput() (string, error) {
-	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadString('\n')
+	var input string
+	var err error
+	if console != nil {
+		input, err = console.ReadLine()
+		typist.stop()
+	} else {
+		input, err = bufio.NewReader(os.Stdin).ReadString('\n')
+	}
 	if err != nil {
 		return "", err
 	}
@@ -33,7 +53,7 @@ func KeyboardInput() (string, error) {
 	}
 	return message, nil
 }
-func HandleRegOrLog(conn net.Conn) *msg.Message {
+func HandleRegOrLog(conn net.Conn, reader *bufio.Reader) *msg.Message {
 	var userMsg *msg.Message
 	for {
 		fmt.Println("1、注册...")
@@ -46,7 +66,7 @@ func HandleRegOrLog(conn net.Conn) *msg.Message {
 		if er != nil {
 			fmt.Println(er)
 		}
-		userMsg = RegisterOrLogin(n, conn)
+		userMsg = RegisterOrLogin(n, conn, reader)
 		if userMsg != nil && n == "2" {
 			break
 		}
@@ -54,9 +74,8 @@ func HandleRegOrLog(conn net.Conn) *msg.Message {
 	return userMsg
 }
 
-// RegisterOrLogin 注册 and 登录处理
-func RegisterOrLogin(n string, conn net.Conn) *msg.Message {
-	reader := bufio.NewReader(conn)
+// RegisterOrLogin 注册 and 登录处理，reader 登录后继续用来读取服务端的消息
+func RegisterOrLogin(n string, conn net.Conn, reader *bufio.Reader) *msg.Message {
 	for {
 		fmt.Println("请输入账户:")
 		username, _ := KeyboardInput()
@@ -86,6 +105,7 @@ func RegisterOrLogin(n string, conn net.Conn) *msg.Message {
 			if n == "1" {
 				fmt.Println("注册成功...")
 			} else {
+				session.login(username, response.Token)
 				fmt.Println("登录成功...")
 			}
 			return loginMes
@@ -96,42 +116,93 @@ func RegisterOrLogin(n string, conn net.Conn) *msg.Message {
 	}
 }
 
-// HandleServerMessage 处理服务端发来的信息
-func HandleServerMessage(conn net.Conn, serverErrFlag chan struct{}) error {
+// HandleServerMessage 处理服务端发来的信息，连接断开时返回；文件传输的下一块直接由这里发出
+func HandleServerMessage(conn net.Conn, reader *bufio.Reader) (err error) {
 	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("client handleServerMessage panic recovered: %v\n", err)
+		if r := recover(); r != nil {
+			log.Printf("client handleServerMessage panic recovered: %v\n", r)
+			err = fmt.Errorf("处理服务端消息出错: %v", r)
 		}
 	}()
-	reader := bufio.NewReader(conn)
 	for {
 		message, err := msg.ReadJsonMessage(reader)
 		if err != nil {
 			return fmt.Errorf("接收服务端消息失败:%w", err)
 		}
+		// 恢复会话时补发的消息可能已经收到过
+		if message.ID != 0 && (message.Type == msg.MessageChat || message.Type == msg.MessagePrivate || message.Type == msg.MessageMention) && !session.observe(message.ID) {
+			continue
+		}
 		switch message.Type {
 		case msg.MessageHeart:
 			//fmt.Println("接收到pong...")
 			continue
 		case msg.MessagePrivate:
-			fmt.Println(message.Sender, "私聊你:", message.Content)
+			showQuote(message)
+			fmt.Println(prefix(message)+message.Sender, "私聊你:", message.Content)
+			reportRead(conn, message)
+		case msg.MessageAck, msg.MessageNack:
+			showAck(message)
+			transfers.finish(message.Seq)
+		case msg.MessageEdit, msg.MessageRecall, msg.MessageDelete:
+			showUpdate(message)
+		case msg.MessageReact, msg.MessageUnreact:
+			showReaction(message)
+		case msg.MessageThread:
+			showThread(message)
+		case msg.MessageMention:
+			showMention(message)
+		case msg.MessageTyping:
+			typers.update(message)
+		case msg.MessageRead:
+			showReceipt(message)
+		case msg.MessageReceipts:
+			showReceipts(message)
+		case msg.MessageSearch:
+			showSearch(message)
+		case msg.MessageHistory:
+			showHistory(message)
+		case msg.MessageFileUpload:
+			sendNextChunk(conn, message)
+		case msg.MessageFileDownload:
+			receiveChunk(conn, message)
+		case msg.MessageCreateRoom, msg.MessageJoinRoom:
+			// 恢复会话时服务端会告知断线前所在的房间，已经在的不用切换
+			if rooms.has(message.Room) {
+				continue
+			}
+			rooms.join(message.Room)
+			fmt.Printf("已加入房间 %s，当前发言房间: %s\n", message.Room, message.Room)
+		case msg.MessageLeaveRoom:
+			rooms.leave(message.Room)
+			// 恢复会话时断线前所在的房间已不存在，服务端带上原因
+			if message.Content != "OK" {
+				fmt.Printf("%s，当前发言房间: %s\n", message.Content, rooms.Current())
+				continue
+			}
+			fmt.Printf("已离开房间 %s，当前发言房间: %s\n", message.Room, rooms.Current())
 		default:
-			fmt.Println(message.Content)
+			showQuote(message)
+			fmt.Println(prefix(message) + message.Content)
 		}
 	}
 }
 
-// StartHeartbeat 发送心跳包
-func StartHeartbeat(username string, conn net.Conn) error {
+// StartHeartbeat 发送心跳包，stop 关闭时返回 nil
+func StartHeartbeat(username string, conn net.Conn, interval time.Duration, stop <-chan struct{}) error {
 	defer func() {
 		if err := recover(); err != nil {
 			log.Printf("client startHeartbeat panic recovered: %v\n", err)
 		}
 	}()
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
-		<-ticker.C
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
 		message := &msg.Message{
 			Type:    msg.MessageHeart,
 			Sender:  username,
@@ -169,11 +240,7 @@ func SendServer(content string, conn net.Conn, userMsg *msg.Message, clientQuitF
 			return
 		}
 		target := strings.TrimPrefix(parts[0], "To:")
-		privateErr := msg.SendJsonMessage(conn, &msg.Message{Type: msg.MessagePrivate, Sender: userMsg.Sender, Receiver: target, Content: parts[1]})
-		if privateErr != nil {
-			log.Println("send msg.MessagePrivate failed...", privateErr)
-		}
-		fmt.Println("发送成功...")
+		sendTracked(conn, &msg.Message{Type: msg.MessagePrivate, Sender: userMsg.Sender, Receiver: target, Content: parts[1]})
 		return
 	}
 	if content == "rank" {
@@ -183,8 +250,128 @@ func SendServer(content string, conn net.Conn, userMsg *msg.Message, clientQuitF
 		}
 		return
 	}
-	r := msg.SendJsonMessage(conn, &msg.Message{Type: msg.MessageChat, Sender: userMsg.Sender, Content: content})
-	if r != nil {
-		log.Println("send msg.MessageChat failed...", r)
+	if content == "rooms" {
+		roomsErr := msg.SendJsonMessage(conn, &msg.Message{Type: msg.MessageListRooms, Sender: userMsg.Sender})
+		if roomsErr != nil {
+			log.Println("send msg.MessageListRooms failed...", roomsErr)
+		}
+		return
+	}
+	if command, name, ok := roomCommand(content); ok {
+		if command == "switch" {
+			if !rooms.switchTo(name) {
+				fmt.Printf("你还没有加入房间 %s，请先 join %s\n", name, name)
+				return
+			}
+			fmt.Println("当前发言房间:", name)
+			return
+		}
+		types := map[string]msg.MessageType{"create": msg.MessageCreateRoom, "join": msg.MessageJoinRoom, "leave": msg.MessageLeaveRoom}
+		roomErr := msg.SendJsonMessage(conn, &msg.Message{Type: types[command], Sender: userMsg.Sender, Room: name})
+		if roomErr != nil {
+			log.Println("send room command failed...", roomErr)
+		}
+		return
+	}
+	if command, arg, ok := fileCommand(content); ok {
+		if command == "download" {
+			Download(conn, userMsg.Sender, arg)
+			return
+		}
+		// 以 @用户名 结尾时私发给该用户，否则发到当前发言房间
+		path, receiver, room := arg, "", ""
+		if i := strings.LastIndex(arg, " @"); i >= 0 {
+			path, receiver = strings.TrimSpace(arg[:i]), arg[i+2:]
+		} else if room = rooms.Current(); room == "" {
+			fmt.Println("当前没有加入任何房间，请先 join 房间名")
+			return
+		}
+		SendFile(conn, userMsg.Sender, path, room, receiver)
+		return
+	}
+	if message, ok, err := editCommand(content, userMsg.Sender); ok {
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if editErr := msg.SendJsonMessage(conn, message); editErr != nil {
+			log.Println("send edit command failed...", editErr)
+		}
+		return
+	}
+	if message, ok, err := threadCommand(content, userMsg.Sender); ok {
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if message.Type == msg.MessageThread {
+			if threadErr := msg.SendJsonMessage(conn, message); threadErr != nil {
+				log.Println("send msg.MessageThread failed...", threadErr)
+			}
+			return
+		}
+		sendTracked(conn, message)
+		return
+	}
+	if message, ok, err := reactionCommand(content, userMsg.Sender); ok {
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if reactErr := msg.SendJsonMessage(conn, message); reactErr != nil {
+			log.Println("send reaction failed...", reactErr)
+		}
+		return
+	}
+	if message, ok, err := historyCommand(content, userMsg.Sender); ok {
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if historyErr := msg.SendJsonMessage(conn, message); historyErr != nil {
+			log.Println("send msg.MessageHistory failed...", historyErr)
+		}
+		return
+	}
+	if message, ok := searchCommand(content, userMsg.Sender); ok {
+		if searchErr := msg.SendJsonMessage(conn, message); searchErr != nil {
+			log.Println("send msg.MessageSearch failed...", searchErr)
+		}
+		return
+	}
+	if message, ok := receiptCommand(content, userMsg.Sender); ok {
+		if receiptErr := msg.SendJsonMessage(conn, message); receiptErr != nil {
+			log.Println("send msg.MessageReceipts failed...", receiptErr)
+		}
+		return
+	}
+	if message, ok, err := moderationCommand(content, userMsg.Sender); ok {
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if modErr := msg.SendJsonMessage(conn, message); modErr != nil {
+			log.Println("send moderation command failed...", modErr)
+		}
+		return
+	}
+	current := rooms.Current()
+	if current == "" {
+		fmt.Println("当前没有加入任何房间，请先 join 房间名")
+		return
+	}
+	sendTracked(conn, &msg.Message{Type: msg.MessageChat, Sender: userMsg.Sender, Room: current, Content: content})
+}
+
+// roomCommand 解析 create/join/leave/switch 房间名 形式的命令
+func roomCommand(content string) (command string, name string, ok bool) {
+	fields := strings.Fields(content)
+	if len(fields) != 2 {
+		return "", "", false
+	}
+	switch fields[0] {
+	case "create", "join", "leave", "switch":
+		return fields[0], fields[1], true
 	}
+	return "", "", false
 }
@@ -0,0 +1,207 @@
+package tool
+
+import (
+	"log"
+	"net"
+	"onlineChatRoom/msg"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// typingIdle 超过该时长没有按键就发送停止输入
+const typingIdle = 5 * time.Second
+
+// notChat 这些命令开头的输入不是聊天，不发送正在输入；第一个词还没输完时不知道是不是命令，也不发送
+var notChat = []string{
+	"list", "quit", "rank", "rooms", "create", "join", "leave", "switch", "send-file", "download",
+	"edit", "recall", "react", "unreact", "reply", "thread", "receipts", "search", "history",
+	"kick", "mute", "ban", "unmute", "unban", "delete",
+}
+
+// typingTarget 正在输入的私聊对象或房间
+type typingTarget struct {
+	room     string
+	receiver string
+}
+
+// typistState 本地用户的输入状态
+type typistState struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	username string
+	target   typingTarget // 为空表示没有在输入
+	sentAt   time.Time    // 上次发送正在输入的时间
+	lastKey  time.Time
+}
+
+var typist = &typistState{}
+
+// typingTargetOf 根据正在输入的内容判断发给谁，不是聊天返回 false
+func typingTargetOf(line string) (typingTarget, bool) {
+	if rest, ok := strings.CutPrefix(line, "To:"); ok {
+		receiver, _, found := strings.Cut(rest, "-->")
+		if !found || receiver == "" {
+			return typingTarget{}, false
+		}
+		return typingTarget{receiver: receiver}, true
+	}
+	command, _, complete := strings.Cut(line, " ")
+	if !complete && len(line) <= len("send-file") || slices.Contains(notChat, command) {
+		return typingTarget{}, false
+	}
+	room := rooms.Current()
+	if room == "" {
+		return typingTarget{}, false
+	}
+	return typingTarget{room: room}, true
+}
+
+// attach 连接或重连后改用新连接发送
+func (t *typistState) attach(conn net.Conn, username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conn, t.username, t.target = conn, username, typingTarget{}
+}
+
+// keypress 每次按键后调用，line 是按键后的整行内容；持续输入时每隔 msg.TypingInterval 发送一次
+func (t *typistState) keypress(line string) {
+	target, ok := typingTargetOf(line)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !ok {
+		t.stopLocked()
+		return
+	}
+	now := time.Now()
+	t.lastKey = now
+	if target != t.target {
+		t.stopLocked()
+		t.target = target
+	}
+	if now.Sub(t.sentAt) >= msg.TypingInterval {
+		t.sendLocked(msg.TypingStart)
+		t.sentAt = now
+	}
+}
+
+// stop 输入完成后发送停止输入
+func (t *typistState) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopLocked()
+}
+
+// idle 长时间没有按键时发送停止输入
+func (t *typistState) idle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.lastKey) >= typingIdle {
+		t.stopLocked()
+	}
+}
+
+// stopLocked 正在输入时发送停止输入，调用方需持有锁
+func (t *typistState) stopLocked() {
+	if t.target == (typingTarget{}) {
+		return
+	}
+	t.sendLocked(msg.TypingStop)
+	t.target, t.sentAt = typingTarget{}, time.Time{}
+}
+
+// sendLocked 发送正在输入事件，调用方需持有锁；发送失败不影响输入
+func (t *typistState) sendLocked(content string) {
+	if t.conn == nil {
+		return
+	}
+	err := msg.SendJsonMessage(t.conn, &msg.Message{
+		Type:     msg.MessageTyping,
+		Sender:   t.username,
+		Room:     t.target.room,
+		Receiver: t.target.receiver,
+		Content:  content,
+	})
+	if err != nil {
+		log.Println("send msg.MessageTyping failed...", err)
+	}
+}
+
+// typingKey 正在输入的用户和房间，私聊的房间为空
+type typingKey struct {
+	sender string
+	room   string
+}
+
+// typersState 其他正在输入的用户，超过 msg.TypingTimeout 没有再收到就当作已停止
+type typersState struct {
+	mu    sync.Mutex
+	until map[typingKey]time.Time
+}
+
+var typers = &typersState{until: make(map[typingKey]time.Time)}
+
+// update 收到正在输入或停止输入后更新状态栏
+func (s *typersState) update(message *msg.Message) {
+	s.mu.Lock()
+	key := typingKey{sender: message.Sender, room: message.Room}
+	if message.Content == msg.TypingStart {
+		s.until[key] = time.Now().Add(msg.TypingTimeout)
+	} else {
+		delete(s.until, key)
+	}
+	status := s.statusLocked()
+	s.mu.Unlock()
+	setStatus(status)
+}
+
+// expire 清除超时的输入状态
+func (s *typersState) expire() {
+	s.mu.Lock()
+	now := time.Now()
+	changed := false
+	for key, until := range s.until {
+		if now.After(until) {
+			delete(s.until, key)
+			changed = true
+		}
+	}
+	status := s.statusLocked()
+	s.mu.Unlock()
+	if changed {
+		setStatus(status)
+	}
+}
+
+// statusLocked 状态栏内容，如 "[bob 正在输入…，alice 正在 lobby 输入…] > "，调用方需持有锁
+func (s *typersState) statusLocked() string {
+	if len(s.until) == 0 {
+		return prompt
+	}
+	var items []string
+	for key := range s.until {
+		if key.room == "" {
+			items = append(items, key.sender+" 正在输入…")
+		} else {
+			items = append(items, key.sender+" 正在 "+key.room+" 输入…")
+		}
+	}
+	slices.Sort(items)
+	return "[" + strings.Join(items, "，") + "] " + prompt
+}
+
+// watchTyping 定时发送停止输入和清除超时的输入状态，stop 关闭时返回
+func watchTyping(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			typist.idle()
+			typers.expire()
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultPath 未指定配置文件时尝试读取的路径，文件不存在则跳过
+const defaultPath = "config.yaml"
+
+// Config 服务端与客户端的全部配置
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Client    Client    `yaml:"client"`
+	MySQL     MySQL     `yaml:"mysql"`
+	Redis     Redis     `yaml:"redis"`
+	Heartbeat Heartbeat `yaml:"heartbeat"`
+	Cluster   Cluster   `yaml:"cluster"`
+}
+
+// Server 服务端配置
+type Server struct {
+	Addr          string        `yaml:"addr" usage:"服务端监听地址"`
+	Storage       string        `yaml:"storage" usage:"存储方式: mysql(MySQL+Redis) 或 memory(内存，重启后丢失)"`
+	HistoryLimit  int64         `yaml:"historyLimit" usage:"登录时推送的历史消息条数"`
+	SessionTTL    time.Duration `yaml:"sessionTTL" usage:"登录会话的有效期，断线后在此期间内可以凭令牌免密恢复"`
+	ResumeLimit   int64         `yaml:"resumeLimit" usage:"恢复会话时最多补发的消息条数"`
+	ThreadLimit   int64         `yaml:"threadLimit" usage:"thread 命令最多返回的回复条数"`
+	EditWindow    time.Duration `yaml:"editWindow" usage:"发送后多久之内可以编辑或撤回，管理员删除消息不受限制"`
+	SendQueueSize int           `yaml:"sendQueueSize" usage:"每个连接的发送队列长度，满了直接断开"`
+	WriteTimeout  time.Duration `yaml:"writeTimeout" usage:"单条消息写超时，超时直接断开"`
+	Moderators    []string      `yaml:"moderators" usage:"管理员用户名，逗号分隔，可以踢人、禁言和封禁"`
+	RateLimit     RateLimit     `yaml:"rateLimit"`
+	Files         Files         `yaml:"files"`
+	TLS           ServerTLS     `yaml:"tls"`
+	WebSocket     WebSocket     `yaml:"websocket"`
+}
+
+// 限流处罚方式
+const (
+	PenaltyMute       = "mute"       // 自动禁言
+	PenaltyDisconnect = "disconnect" // 断开连接
+)
+
+// RateLimit 限流配置，每类消息按用户和IP分别用令牌桶限流，超限若干次后自动处罚
+type RateLimit struct {
+	User         RateLimits    `yaml:"user"`
+	IP           RateLimits    `yaml:"ip"`
+	Warnings     int           `yaml:"warnings" usage:"超限时先警告的次数，之后再超限就处罚"`
+	StrikeWindow time.Duration `yaml:"strikeWindow" usage:"超过该时长没有再超限则警告次数清零"`
+	Penalty      string        `yaml:"penalty" usage:"处罚方式: mute(自动禁言，刷 list/rank 时断开连接) 或 disconnect(断开连接)"`
+	MuteDuration time.Duration `yaml:"muteDuration" usage:"自动禁言的时长"`
+}
+
+// RateLimits 各类消息的限额
+type RateLimits struct {
+	Chat    Limit `yaml:"chat"`
+	Private Limit `yaml:"private"`
+	List    Limit `yaml:"list"`
+	Rank    Limit `yaml:"rank"`
+	File    Limit `yaml:"file"` // 文件的每一块，超限时等待而不是拒绝
+	Read    Limit `yaml:"read"` // 私聊的已读上报，超限时等待而不是拒绝
+}
+
+// Limit 令牌桶限额
+type Limit struct {
+	Every time.Duration `yaml:"every" usage:"每隔多久恢复一条额度，0 表示不限制"`
+	Burst int           `yaml:"burst" usage:"额度上限，即最多可以连续发送的条数"`
+}
+
+// Files 文件传输配置，文件存在服务端本地磁盘，集群部署时 dir 需是各节点共享的目录
+type Files struct {
+	Dir         string        `yaml:"dir" usage:"上传文件的存放目录"`
+	MaxFileSize int64         `yaml:"maxFileSize" usage:"单个文件的大小上限，字节"`
+	Quota       int64         `yaml:"quota" usage:"每个用户可以占用的空间，字节"`
+	ChunkSize   int           `yaml:"chunkSize" usage:"分块大小，字节，base64 编码后要小于单条消息的长度上限"`
+	UploadTTL   time.Duration `yaml:"uploadTTL" usage:"超过该时长仍未完成的上传会被清除"`
+}
+
+// WebSocket 浏览器接入的 WebSocket 网关配置
+type WebSocket struct {
+	Addr           string   `yaml:"addr" usage:"WebSocket 监听地址，为空则不启用"`
+	Path           string   `yaml:"path" usage:"WebSocket 路径"`
+	AllowedOrigins []string `yaml:"allowedOrigins" usage:"允许连接的页面来源，逗号分隔，* 表示任意来源，为空只允许同源"`
+}
+
+// Client 客户端配置
+type Client struct {
+	ServerAddr  string    `yaml:"serverAddr" usage:"客户端连接的服务端地址"`
+	DownloadDir string    `yaml:"downloadDir" usage:"下载文件的保存目录"`
+	Reconnect   Reconnect `yaml:"reconnect"`
+	TLS         ClientTLS `yaml:"tls"`
+}
+
+// Reconnect 客户端断线重连配置，等待时间按指数退避并加随机抖动
+type Reconnect struct {
+	MinDelay   time.Duration `yaml:"minDelay" usage:"断线后第一次重连前的等待时间，之后每失败一次翻倍"`
+	MaxDelay   time.Duration `yaml:"maxDelay" usage:"重连等待时间的上限"`
+	OutboxSize int           `yaml:"outboxSize" usage:"断线期间最多暂存的待发送输入条数"`
+}
+
+// MySQL 数据库配置
+type MySQL struct {
+	DSN string `yaml:"dsn" usage:"MySQL 连接串"`
+}
+
+// Redis 缓存配置
+type Redis struct {
+	Addr         string `yaml:"addr" usage:"Redis 地址"`
+	Password     string `yaml:"password" usage:"Redis 密码"`
+	DB           int    `yaml:"db" usage:"Redis 库编号"`
+	PoolSize     int    `yaml:"poolSize" usage:"Redis 连接池大小"`
+	StreamMaxLen int64  `yaml:"streamMaxLen" usage:"聊天 streams 流的最大长度"`
+}
+
+// Heartbeat 心跳配置
+type Heartbeat struct {
+	Interval      time.Duration `yaml:"interval" usage:"客户端发送心跳的间隔"`
+	CheckInterval time.Duration `yaml:"checkInterval" usage:"服务端检测心跳的间隔"`
+	Timeout       time.Duration `yaml:"timeout" usage:"超过该时长未收到心跳则强制下线"`
+	ReadDeadline  time.Duration `yaml:"readDeadline" usage:"收到心跳后连接的读超时"`
+}
+
+// 存储方式
+const (
+	StorageMySQL  = "mysql"  // 用户和聊天记录存 MySQL，消息流和排行存 Redis
+	StorageMemory = "memory" // 全部存内存，不依赖外部服务，用于本地开发
+)
+
+// Cluster 多节点部署配置
+type Cluster struct {
+	Enabled bool   `yaml:"enabled" usage:"多个服务端节点共享同一套 MySQL 和 Redis，启动时不再清空 Redis"`
+	NodeID  string `yaml:"nodeID" usage:"节点ID，集群内唯一，也是读取 streams 流的消费组名，重启后需保持不变，默认为主机名"`
+}
+
+// Default 默认配置
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:          ":8080",
+			Storage:       StorageMySQL,
+			HistoryLimit:  10,
+			SessionTTL:    24 * time.Hour,
+			ResumeLimit:   200,
+			ThreadLimit:   100,
+			EditWindow:    2 * time.Minute,
+			SendQueueSize: 256,
+			WriteTimeout:  10 * time.Second,
+			RateLimit: RateLimit{
+				User: RateLimits{
+					Chat:    Limit{Every: 500 * time.Millisecond, Burst: 10},
+					Private: Limit{Every: 500 * time.Millisecond, Burst: 10},
+					List:    Limit{Every: 2 * time.Second, Burst: 3},
+					Rank:    Limit{Every: 2 * time.Second, Burst: 3},
+					File:    Limit{Every: 10 * time.Millisecond, Burst: 20},
+					Read:    Limit{Every: 50 * time.Millisecond, Burst: 50},
+				},
+				// 同一出口IP下可能有多个用户，限额放宽
+				IP: RateLimits{
+					Chat:    Limit{Every: 100 * time.Millisecond, Burst: 30},
+					Private: Limit{Every: 100 * time.Millisecond, Burst: 30},
+					List:    Limit{Every: 500 * time.Millisecond, Burst: 10},
+					Rank:    Limit{Every: 500 * time.Millisecond, Burst: 10},
+					File:    Limit{Every: 5 * time.Millisecond, Burst: 50},
+					Read:    Limit{Every: 20 * time.Millisecond, Burst: 100},
+				},
+				Warnings:     3,
+				StrikeWindow: time.Minute,
+				Penalty:      PenaltyMute,
+				MuteDuration: 5 * time.Minute,
+			},
+			Files: Files{
+				Dir:         "files",
+				MaxFileSize: 100 << 20,
+				Quota:       1 << 30,
+				ChunkSize:   256 << 10,
+				UploadTTL:   24 * time.Hour,
+			},
+			WebSocket: WebSocket{
+				Path: "/ws",
+			},
+		},
+		Client: Client{
+			ServerAddr:  "localhost:8080",
+			DownloadDir: "downloads",
+			Reconnect: Reconnect{
+				MinDelay:   time.Second,
+				MaxDelay:   30 * time.Second,
+				OutboxSize: 100,
+			},
+		},
+		MySQL: MySQL{
+			DSN: "root@tcp(localhost:3306)/onlinechatroom?charset=utf8mb4&parseTime=True&loc=Local",
+		},
+		Redis: Redis{
+			Addr:         "localhost:6379",
+			PoolSize:     100,
+			StreamMaxLen: 100,
+		},
+		Heartbeat: Heartbeat{
+			Interval:      10 * time.Second,
+			CheckInterval: 10 * time.Second,
+			Timeout:       20 * time.Second,
+			ReadDeadline:  30 * time.Second,
+		},
+	}
+}
+
+// Load 依次加载默认值、配置文件、环境变量和命令行参数，后者覆盖前者
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	fields := collectFields(cfg)
+
+	fs := flag.NewFlagSet("onlineChatRoom", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "配置文件路径(默认 "+defaultPath+")")
+	for _, f := range fields {
+		fs.Var(f, f.name, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("parse flags failed:%w", err)
+	}
+
+	// 配置文件
+	if err := loadFile(cfg, *path); err != nil {
+		return nil, err
+	}
+	// 环境变量
+	for _, f := range fields {
+		raw, ok := os.LookupEnv(f.env)
+		if !ok {
+			continue
+		}
+		if err := setValue(f.value, raw); err != nil {
+			return nil, fmt.Errorf("环境变量 %s 无效:%w", f.env, err)
+		}
+	}
+	// 命令行参数
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		if f, ok := fl.Value.(*field); ok && flagErr == nil {
+			flagErr = f.apply()
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if cfg.Cluster.NodeID == "" {
+		cfg.Cluster.NodeID, _ = os.Hostname()
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile 读取 YAML 配置文件
+func loadFile(cfg *Config, path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = defaultPath
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("读取配置文件 %s 失败:%w", path, err)
+	}
+	if err = yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("解析配置文件 %s 失败:%w", path, err)
+	}
+	return nil
+}
+
+// Validate 启动时校验配置
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, a ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+	check(c.Server.Addr != "", "server.addr 不能为空")
+	check(c.Server.Storage == StorageMySQL || c.Server.Storage == StorageMemory, "server.storage 只能是 %s 或 %s", StorageMySQL, StorageMemory)
+	check(c.Server.HistoryLimit >= 0, "server.historyLimit 不能为负数")
+	check(c.Server.SessionTTL > 0, "server.sessionTTL 必须大于0")
+	check(c.Server.ResumeLimit > 0, "server.resumeLimit 必须大于0")
+	check(c.Server.ThreadLimit > 0, "server.threadLimit 必须大于0")
+	check(c.Server.EditWindow >= 0, "server.editWindow 不能为负数")
+	check(c.Server.SendQueueSize > 0, "server.sendQueueSize 必须大于0")
+	check(c.Server.WriteTimeout > 0, "server.writeTimeout 必须大于0")
+	for name, limit := range map[string]Limit{
+		"user.chat": c.Server.RateLimit.User.Chat, "user.private": c.Server.RateLimit.User.Private,
+		"user.list": c.Server.RateLimit.User.List, "user.rank": c.Server.RateLimit.User.Rank,
+		"ip.chat": c.Server.RateLimit.IP.Chat, "ip.private": c.Server.RateLimit.IP.Private,
+		"ip.list": c.Server.RateLimit.IP.List, "ip.rank": c.Server.RateLimit.IP.Rank,
+		"user.file": c.Server.RateLimit.User.File, "user.read": c.Server.RateLimit.User.Read,
+		"ip.file": c.Server.RateLimit.IP.File, "ip.read": c.Server.RateLimit.IP.Read,
+	} {
+		check(limit.Every >= 0, "server.rateLimit.%s.every 不能为负数", name)
+		check(limit.Every == 0 || limit.Burst > 0, "server.rateLimit.%s.burst 必须大于0", name)
+	}
+	check(c.Server.RateLimit.Warnings >= 0, "server.rateLimit.warnings 不能为负数")
+	check(c.Server.RateLimit.StrikeWindow > 0, "server.rateLimit.strikeWindow 必须大于0")
+	check(c.Server.RateLimit.Penalty == PenaltyMute || c.Server.RateLimit.Penalty == PenaltyDisconnect,
+		"server.rateLimit.penalty 只能是 %s 或 %s", PenaltyMute, PenaltyDisconnect)
+	if c.Server.RateLimit.Penalty == PenaltyMute {
+		check(c.Server.RateLimit.MuteDuration > 0, "server.rateLimit.muteDuration 必须大于0")
+	}
+	check(c.Server.Files.Dir != "", "server.files.dir 不能为空")
+	check(c.Server.Files.MaxFileSize > 0, "server.files.maxFileSize 必须大于0")
+	check(c.Server.Files.Quota >= c.Server.Files.MaxFileSize, "server.files.quota 不能小于 server.files.maxFileSize")
+	// base64 编码后约为 4/3，还要留出其他字段的空间
+	check(c.Server.Files.ChunkSize > 0 && c.Server.Files.ChunkSize <= 512<<10, "server.files.chunkSize 必须在 1 到 524288 之间")
+	check(c.Server.Files.UploadTTL > 0, "server.files.uploadTTL 必须大于0")
+	if c.Server.TLS.Enabled {
+		check(c.Server.TLS.CertFile != "" && c.Server.TLS.KeyFile != "", "启用 TLS 时 server.tls.certFile 和 server.tls.keyFile 不能为空")
+	}
+	if c.Server.WebSocket.Addr != "" {
+		check(strings.HasPrefix(c.Server.WebSocket.Path, "/"), "server.websocket.path 必须以 / 开头")
+	}
+	check(c.Client.ServerAddr != "", "client.serverAddr 不能为空")
+	check(c.Client.DownloadDir != "", "client.downloadDir 不能为空")
+	check(c.Client.Reconnect.MinDelay > 0, "client.reconnect.minDelay 必须大于0")
+	check(c.Client.Reconnect.MaxDelay >= c.Client.Reconnect.MinDelay, "client.reconnect.maxDelay 不能小于 client.reconnect.minDelay")
+	check(c.Client.Reconnect.OutboxSize >= 0, "client.reconnect.outboxSize 不能为负数")
+	check((c.Client.TLS.CertFile == "") == (c.Client.TLS.KeyFile == ""), "client.tls.certFile 和 client.tls.keyFile 必须同时配置")
+	check(c.MySQL.DSN != "", "mysql.dsn 不能为空")
+	check(c.Redis.Addr != "", "redis.addr 不能为空")
+	check(c.Redis.PoolSize > 0, "redis.poolSize 必须大于0")
+	check(c.Redis.StreamMaxLen > 0, "redis.streamMaxLen 必须大于0")
+	check(c.Heartbeat.Interval > 0, "heartbeat.interval 必须大于0")
+	check(c.Heartbeat.CheckInterval > 0, "heartbeat.checkInterval 必须大于0")
+	check(c.Heartbeat.Timeout > c.Heartbeat.Interval, "heartbeat.timeout 必须大于 heartbeat.interval")
+	check(c.Heartbeat.ReadDeadline >= c.Heartbeat.Timeout, "heartbeat.readDeadline 不能小于 heartbeat.timeout")
+	check(c.Cluster.NodeID != "", "cluster.nodeID 不能为空")
+	if c.Cluster.Enabled {
+		check(c.Server.Storage == StorageMySQL, "cluster.enabled 需要 server.storage 为 %s，内存存储无法在节点间共享", StorageMySQL)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("配置无效: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	yaml := "server:\n  addr: \":9000\"\n  historyLimit: 20\nheartbeat:\n  interval: 5s\n"
+	if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		check   func(*Config) bool
+		wantErr string
+	}{
+		{"配置文件覆盖默认值", nil, []string{"-config", file},
+			func(c *Config) bool {
+				return c.Server.Addr == ":9000" && c.Server.HistoryLimit == 20 && c.Heartbeat.Interval == 5*time.Second && c.Server.SendQueueSize == 256
+			}, ""},
+		{"环境变量覆盖配置文件", map[string]string{"CHATROOM_SERVER_ADDR": ":9001"}, []string{"-config", file},
+			func(c *Config) bool { return c.Server.Addr == ":9001" }, ""},
+		{"命令行参数覆盖环境变量", map[string]string{"CHATROOM_SERVER_ADDR": ":9001"}, []string{"-config", file, "-server.addr", ":9002"},
+			func(c *Config) bool { return c.Server.Addr == ":9002" }, ""},
+		{"嵌套字段", nil, []string{"-server.rateLimit.user.chat.burst", "5", "-cluster.nodeID", "node1"},
+			func(c *Config) bool { return c.Server.RateLimit.User.Chat.Burst == 5 && c.Cluster.NodeID == "node1" }, ""},
+		{"没有配置文件时使用默认值", map[string]string{"CHATROOM_CONFIG": ""}, nil,
+			func(c *Config) bool { return c.Server.Addr == ":8080" && c.Cluster.NodeID != "" }, ""},
+		{"指定的配置文件不存在", nil, []string{"-config", filepath.Join(dir, "missing.yaml")}, nil, "读取配置文件"},
+		{"环境变量格式错误", map[string]string{"CHATROOM_SERVER_HISTORYLIMIT": "many"}, nil, nil, "CHATROOM_SERVER_HISTORYLIMIT"},
+		{"校验失败", nil, []string{"-server.storage", "disk"}, nil, "server.storage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 默认的配置文件在当前目录，切到空目录免得读到开发者自己的配置
+			t.Chdir(t.TempDir())
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg, err := Load(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v，应包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(cfg) {
+				t.Fatalf("加载结果不对: %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string // 为空表示应通过校验
+	}{
+		{"默认配置", func(*Config) {}, ""},
+		{"存储方式", func(c *Config) { c.Server.Storage = "disk" }, "server.storage"},
+		{"限额为负", func(c *Config) { c.Server.RateLimit.IP.Read.Every = -time.Second }, "server.rateLimit.ip.read.every"},
+		{"限流但没有额度", func(c *Config) { c.Server.RateLimit.User.File.Burst = 0 }, "server.rateLimit.user.file.burst"},
+		{"不限流时额度可以为0", func(c *Config) { c.Server.RateLimit.User.Chat = Limit{} }, ""},
+		{"配额小于单个文件", func(c *Config) { c.Server.Files.Quota = c.Server.Files.MaxFileSize - 1 }, "server.files.quota"},
+		{"TLS 缺少证书", func(c *Config) { c.Server.TLS.Enabled = true }, "server.tls.certFile"},
+		{"客户端证书和私钥不成对", func(c *Config) { c.Client.TLS.CertFile = "client.crt" }, "client.tls.keyFile"},
+		{"心跳超时不大于间隔", func(c *Config) { c.Heartbeat.Timeout = c.Heartbeat.Interval }, "heartbeat.timeout"},
+		{"集群不能用内存存储", func(c *Config) { c.Cluster.Enabled = true; c.Server.Storage = StorageMemory }, "cluster.enabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Cluster.NodeID = "test"
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v，应包含 %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix 环境变量前缀，如 server.addr 对应 CHATROOM_SERVER_ADDR
+const envPrefix = "CHATROOM_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field 一个可被环境变量和命令行参数覆盖的配置项
+type field struct {
+	name  string // 命令行参数名，与 YAML 路径一致，如 server.addr
+	env   string // 环境变量名
+	usage string
+	value reflect.Value
+	raw   string // 命令行传入的原始值，等配置文件加载完后再生效
+}
+
+// collectFields 按 yaml 标签遍历配置结构体，收集所有叶子字段
+func collectFields(cfg *Config) []*field {
+	var fields []*field
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name := sf.Tag.Get("yaml")
+			if name == "" || name == "-" {
+				continue
+			}
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+				walk(fv, name)
+				continue
+			}
+			fields = append(fields, &field{
+				name:  name,
+				env:   envPrefix + strings.ToUpper(strings.ReplaceAll(name, ".", "_")),
+				usage: sf.Tag.Get("usage"),
+				value: fv,
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return fields
+}
+
+func (f *field) String() string {
+	return f.raw
+}
+
+// IsBoolFlag 布尔参数允许只写 -name
+func (f *field) IsBoolFlag() bool {
+	return f.value.Kind() == reflect.Bool
+}
+
+// Set 解析命令行参数时只做格式校验并记录原始值
+func (f *field) Set(s string) error {
+	tmp := reflect.New(f.value.Type()).Elem()
+	if err := setValue(tmp, s); err != nil {
+		return err
+	}
+	f.raw = s
+	return nil
+}
+
+// apply 把命令行参数写入配置
+func (f *field) apply() error {
+	if err := setValue(f.value, f.raw); err != nil {
+		return fmt.Errorf("参数 -%s 无效:%w", f.name, err)
+	}
+	return nil
+}
+
+// setValue 把字符串解析为字段对应的类型
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("不支持的配置类型 %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("不支持的配置类型 %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// ServerTLS 服务端 TLS 配置，配置了 ClientCAFile 即要求客户端证书(双向认证)
+type ServerTLS struct {
+	Enabled      bool   `yaml:"enabled" usage:"服务端是否启用 TLS"`
+	CertFile     string `yaml:"certFile" usage:"服务端证书路径"`
+	KeyFile      string `yaml:"keyFile" usage:"服务端私钥路径"`
+	ClientCAFile string `yaml:"clientCAFile" usage:"校验客户端证书的 CA 路径，配置后开启双向认证"`
+}
+
+// ClientTLS 客户端 TLS 配置
+type ClientTLS struct {
+	Enabled    bool   `yaml:"enabled" usage:"客户端是否使用 TLS 连接"`
+	CAFile     string `yaml:"caFile" usage:"校验服务端证书的 CA 路径，为空则使用系统根证书"`
+	CertFile   string `yaml:"certFile" usage:"客户端证书路径(双向认证时使用)"`
+	KeyFile    string `yaml:"keyFile" usage:"客户端私钥路径(双向认证时使用)"`
+	ServerName string `yaml:"serverName" usage:"校验服务端证书时使用的域名，为空则取连接地址"`
+}
+
+// Config 生成服务端 tls.Config
+func (t ServerTLS) Config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载服务端证书失败:%w", err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if t.ClientCAFile != "" {
+		pool, err := loadCertPool(t.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsCfg, nil
+}
+
+// Config 生成客户端 tls.Config
+func (t ClientTLS) Config() (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		ServerName: t.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if t.CAFile != "" {
+		pool, err := loadCertPool(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败:%w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// loadCertPool 读取 PEM 格式的 CA 证书
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 CA 证书 %s 失败:%w", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA 证书 %s 中没有有效的证书", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// fileColumns files 表查询的列
+const fileColumns = "id,owner,room,receiver,name,size,checksum,created_at,completed_at"
+
+// AddFile 登记一次上传
+func (s *MySQLStore) AddFile(file File) (int64, error) {
+	sqlStr := "insert into files(owner,room,receiver,name,size,checksum,created_at) values (?,?,?,?,?,?,?)"
+	res, err := s.db.Exec(sqlStr, file.Owner, file.Room, file.Receiver, file.Name, file.Size, file.Checksum, file.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("AddFile failed:%w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("AddFile failed:%w", err)
+	}
+	return id, nil
+}
+
+// GetFile 按ID查询文件
+func (s *MySQLStore) GetFile(id int64) (*File, error) {
+	var file File
+	sqlStr := "select " + fileColumns + " from files where id = ?"
+	err := s.db.Get(&file, sqlStr, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("GetFile failed:%w", err)
+	}
+	return &file, nil
+}
+
+// FindUpload 查找尚未完成的同一文件上传
+func (s *MySQLStore) FindUpload(owner string, room string, receiver string, checksum string) (*File, error) {
+	var file File
+	sqlStr := "select " + fileColumns + " from files where owner = ? and checksum = ? and room = ? and receiver = ? and completed_at is null order by id desc limit 1"
+	err := s.db.Get(&file, sqlStr, owner, checksum, room, receiver)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("FindUpload failed:%w", err)
+	}
+	return &file, nil
+}
+
+// CompleteFile 标记上传完成
+func (s *MySQLStore) CompleteFile(id int64, completedAt time.Time) error {
+	sqlStr := "update files set completed_at = ? where id = ?"
+	_, err := s.db.Exec(sqlStr, completedAt, id)
+	if err != nil {
+		return fmt.Errorf("CompleteFile failed:%w", err)
+	}
+	return nil
+}
+
+// DeleteFile 删除登记
+func (s *MySQLStore) DeleteFile(id int64) error {
+	sqlStr := "delete from files where id = ?"
+	_, err := s.db.Exec(sqlStr, id)
+	if err != nil {
+		return fmt.Errorf("DeleteFile failed:%w", err)
+	}
+	return nil
+}
+
+// UsedSpace 用户已占用的空间
+func (s *MySQLStore) UsedSpace(owner string) (int64, error) {
+	var used int64
+	sqlStr := "select coalesce(sum(size),0) from files where owner = ?"
+	err := s.db.Get(&used, sqlStr, owner)
+	if err != nil {
+		return 0, fmt.Errorf("UsedSpace failed:%w", err)
+	}
+	return used, nil
+}
+
+// StaleUploads 查询长时间未完成的上传
+func (s *MySQLStore) StaleUploads(owner string, before time.Time) ([]File, error) {
+	var files []File
+	sqlStr := "select " + fileColumns + " from files where owner = ? and completed_at is null and created_at < ?"
+	err := s.db.Select(&files, sqlStr, owner, before)
+	if err != nil {
+		return nil, fmt.Errorf("StaleUploads failed:%w", err)
+	}
+	return files, nil
+}
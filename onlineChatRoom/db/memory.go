@@ -0,0 +1,926 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore 内存实现的全部存储，用于单元测试和本地开发，重启后数据丢失，不能在多个节点间共享
+type MemoryStore struct {
+	mu           sync.Mutex
+	streamMaxLen int64
+
+	users      map[string]string // 用户名 -> 密码(哈希)
+	noReceipts map[string]bool   // 关闭了已读回执的用户
+
+	messages  []ArchivedMessage
+	messageID int64
+
+	offline   []OfflineMessage
+	offlineID int64
+
+	mentions map[string][]int64 // 用户名 -> 提到该用户的消息ID
+
+	sanctions map[string]Sanction // 用户名+类型 -> 处罚
+
+	streams   map[string][]StreamEntry           // 房间 -> 流
+	streamSeq int64                              // 流ID的序号部分，所有房间共用，保证单调递增
+	changed   chan struct{}                      // 有新消息时关闭并替换，唤醒阻塞的 Read
+	groups    map[string]map[string]*memoryGroup // 房间 -> 消费组名 -> 消费组
+
+	rank map[string]float64
+
+	presence map[string]presence // 用户名 -> 在线记录
+	rooms    map[string]string   // 房间名 -> 创建者
+
+	memberRooms map[string]memberRooms // 用户名 -> 断线时所在的房间
+
+	sessions map[string]memorySession // 令牌 -> 会话
+
+	files  map[int64]File
+	fileID int64
+
+	reactions map[int64][]string // 消息ID -> 按先后排列的 "表情 用户名"
+
+	subscribers []chan Event // 即时事件的订阅者
+}
+
+// memoryGroup 消费组
+type memoryGroup struct {
+	delivered int64                   // 已读取到的流ID序号
+	pending   map[string]pendingEntry // 已读取未确认的流ID
+}
+
+// pendingEntry 已读取未确认的消息
+type pendingEntry struct {
+	consumer string
+	since    time.Time
+}
+
+// memberRooms 带过期时间的断线时所在的房间
+type memberRooms struct {
+	rooms   []string
+	expires time.Time
+}
+
+// memorySession 带过期时间的会话
+type memorySession struct {
+	session Session
+	expires time.Time
+}
+
+// presence 在线记录
+type presence struct {
+	node    string
+	expires time.Time
+}
+
+func NewMemoryStore(streamMaxLen int64) *MemoryStore {
+	return &MemoryStore{
+		streamMaxLen: streamMaxLen,
+		users:        make(map[string]string),
+		sanctions:    make(map[string]Sanction),
+		streams:      make(map[string][]StreamEntry),
+		groups:       make(map[string]map[string]*memoryGroup),
+		changed:      make(chan struct{}),
+		rank:         make(map[string]float64),
+		presence:     make(map[string]presence),
+		rooms:        make(map[string]string),
+		memberRooms:  make(map[string]memberRooms),
+		sessions:     make(map[string]memorySession),
+		files:        make(map[int64]File),
+		mentions:     make(map[string][]int64),
+		noReceipts:   make(map[string]bool),
+		reactions:    make(map[int64][]string),
+	}
+}
+
+// AddUser 注册用户
+func (s *MemoryStore) AddUser(username string, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[username]; ok {
+		return ErrUserExists
+	}
+	s.users[username] = password
+	return nil
+}
+
+// SearchUser 查询用户
+func (s *MemoryStore) SearchUser(username string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	password, ok := s.users[username]
+	if !ok {
+		return "", ErrUserNotFound
+	}
+	return password, nil
+}
+
+// UpdatePassword 更新用户密码
+func (s *MemoryStore) UpdatePassword(username string, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[username]; !ok {
+		return ErrUserNotFound
+	}
+	s.users[username] = password
+	return nil
+}
+
+// SetReadReceipts 开启或关闭已读回执
+func (s *MemoryStore) SetReadReceipts(username string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if enabled {
+		delete(s.noReceipts, username)
+	} else {
+		s.noReceipts[username] = true
+	}
+	return nil
+}
+
+// ReadReceipts 查询是否开启了已读回执
+func (s *MemoryStore) ReadReceipts(username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.noReceipts[username], nil
+}
+
+// SaveMessage 归档一条消息
+func (s *MemoryStore) SaveMessage(m ArchivedMessage) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messageID++
+	m.Id, m.StreamId = s.messageID, ""
+	s.messages = append(s.messages, m)
+	return s.messageID, nil
+}
+
+// UpdateStreamID 回填流ID
+func (s *MemoryStore) UpdateStreamID(id int64, streamID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.findMessage(id); m != nil {
+		m.StreamId = streamID
+	}
+	return nil
+}
+
+// DeleteMessage 删除归档
+func (s *MemoryStore) DeleteMessage(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.messages {
+		if s.messages[i].Id == id {
+			s.messages = append(s.messages[:i], s.messages[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// findMessage 按ID查找归档，调用方需持有锁
+func (s *MemoryStore) findMessage(id int64) *ArchivedMessage {
+	i := sort.Search(len(s.messages), func(i int) bool { return s.messages[i].Id >= id })
+	if i < len(s.messages) && s.messages[i].Id == id {
+		return &s.messages[i]
+	}
+	return nil
+}
+
+// GetMessage 按ID查询归档
+func (s *MemoryStore) GetMessage(id int64) (*ArchivedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.findMessage(id)
+	if m == nil {
+		return nil, ErrMessageNotFound
+	}
+	res := *m
+	return &res, nil
+}
+
+// EditMessage 修改消息内容
+func (s *MemoryStore) EditMessage(id int64, content string, editedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.findMessage(id); m != nil {
+		m.Content = content
+		m.EditedAt = &editedAt
+	}
+	for i := range s.offline {
+		if s.offline[i].MessageId == id {
+			s.offline[i].Content = content
+		}
+	}
+	return nil
+}
+
+// RecallMessage 撤回或删除消息
+func (s *MemoryStore) RecallMessage(id int64, by string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.findMessage(id); m != nil {
+		m.Content = ""
+		m.RecalledBy = by
+	}
+	s.offline = slices.DeleteFunc(s.offline, func(m OfflineMessage) bool { return m.MessageId == id })
+	return nil
+}
+
+// Thread 话题中最新的回复
+func (s *MemoryStore) Thread(threadID int64, limit int64) ([]ArchivedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []ArchivedMessage
+	for i := len(s.messages) - 1; i >= 0 && int64(len(res)) < limit; i-- {
+		if s.messages[i].ThreadId == threadID {
+			res = append(res, s.messages[i])
+		}
+	}
+	slices.Reverse(res)
+	return res, nil
+}
+
+// History 房间最近的群聊
+func (s *MemoryStore) History(room string, beforeID int64, limit int64) ([]ArchivedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []ArchivedMessage
+	for i := len(s.messages) - 1; i >= 0 && int64(len(res)) < limit; i-- {
+		m := s.messages[i]
+		if beforeID != 0 && m.Id >= beforeID {
+			continue
+		}
+		if m.Room == room && m.Receiver == "" {
+			res = append(res, m)
+		}
+	}
+	slices.Reverse(res)
+	return res, nil
+}
+
+// Conversation 两个用户之间的私聊
+func (s *MemoryStore) Conversation(username string, other string, beforeID int64, limit int64) ([]ArchivedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []ArchivedMessage
+	for i := len(s.messages) - 1; i >= 0 && int64(len(res)) < limit; i-- {
+		m := s.messages[i]
+		if beforeID != 0 && m.Id >= beforeID {
+			continue
+		}
+		if m.Sender == username && m.Receiver == other || m.Sender == other && m.Receiver == username {
+			res = append(res, m)
+		}
+	}
+	slices.Reverse(res)
+	return res, nil
+}
+
+// Missed 用户错过的群聊和私聊
+func (s *MemoryStore) Missed(username string, rooms []string, afterID int64, since time.Time, limit int64) ([]ArchivedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []ArchivedMessage
+	for i := len(s.messages) - 1; i >= 0 && int64(len(res)) < limit; i-- {
+		m := s.messages[i]
+		if m.Id <= afterID {
+			break
+		}
+		if m.CreatedAt.Before(since) {
+			continue
+		}
+		group := m.Receiver == "" && m.Sender != username && slices.Contains(rooms, m.Room)
+		if group || m.Receiver == username {
+			res = append(res, m)
+		}
+	}
+	slices.Reverse(res)
+	return res, nil
+}
+
+// MarkDelivered 记下私聊送达的时间
+func (s *MemoryStore) MarkDelivered(id int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.findMessage(id); m != nil && m.DeliveredAt == nil {
+		m.DeliveredAt = &at
+	}
+	return nil
+}
+
+// MarkRead 记下私聊已读的时间
+func (s *MemoryStore) MarkRead(id int64, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.findMessage(id)
+	if m == nil || m.ReadAt != nil {
+		return false, nil
+	}
+	m.ReadAt = &at
+	if m.DeliveredAt == nil {
+		m.DeliveredAt = &at
+	}
+	return true, nil
+}
+
+// SentPrivate 查询用户最近发出的私聊
+func (s *MemoryStore) SentPrivate(sender string, limit int64) ([]ArchivedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []ArchivedMessage
+	for i := len(s.messages) - 1; i >= 0 && int64(len(res)) < limit; i-- {
+		if m := s.messages[i]; m.Sender == sender && m.Receiver != "" {
+			res = append(res, m)
+		}
+	}
+	slices.Reverse(res)
+	return res, nil
+}
+
+// Search 搜索消息
+func (s *MemoryStore) Search(q SearchQuery) ([]ArchivedMessage, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []ArchivedMessage
+	var total int64
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if m := s.messages[i]; matchSearch(m, q) {
+			if total >= q.Offset && int64(len(res)) < q.Limit {
+				res = append(res, m)
+			}
+			total++
+		}
+	}
+	return res, total, nil
+}
+
+// matchSearch 消息是否符合搜索条件
+func matchSearch(m ArchivedMessage, q SearchQuery) bool {
+	if m.RecalledBy != "" || m.Receiver != "" && m.Sender != q.Username && m.Receiver != q.Username {
+		return false
+	}
+	if m.Receiver == "" && !slices.Contains(q.Rooms, m.Room) {
+		return false
+	}
+	content := strings.ToLower(m.Content)
+	for _, keyword := range q.Keywords {
+		if !strings.Contains(content, strings.ToLower(keyword)) {
+			return false
+		}
+	}
+	switch {
+	case q.Sender != "" && m.Sender != q.Sender:
+		return false
+	case q.Room != "" && (m.Room != q.Room || m.Receiver != ""):
+		return false
+	case q.With != "" && m.Sender != q.With && m.Receiver != q.With:
+		return false
+	case q.With != "" && m.Receiver == "":
+		return false
+	case !q.Since.IsZero() && m.CreatedAt.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !m.CreatedAt.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// AddMention 存入提醒收件箱
+func (s *MemoryStore) AddMention(username string, messageID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mentions[username] = append(s.mentions[username], messageID)
+	return nil
+}
+
+// Mentions 查询收件箱中提到该用户的消息
+func (s *MemoryStore) Mentions(username string, limit int64) ([]ArchivedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := s.mentions[username]
+	var res []ArchivedMessage
+	for i := len(ids) - 1; i >= 0 && int64(len(res)) < limit; i-- {
+		if m := s.findMessage(ids[i]); m != nil {
+			res = append(res, *m)
+		}
+	}
+	slices.Reverse(res)
+	return res, nil
+}
+
+// DeleteMentions 清除已展示的提醒
+func (s *MemoryStore) DeleteMentions(username string, lastID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mentions[username] = slices.DeleteFunc(s.mentions[username], func(id int64) bool { return id <= lastID })
+	return nil
+}
+
+// AddOffline 暂存离线私聊
+func (s *MemoryStore) AddOffline(messageID int64, sender string, receiver string, content string, createdAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offlineID++
+	s.offline = append(s.offline, OfflineMessage{
+		Id:        s.offlineID,
+		MessageId: messageID,
+		Sender:    sender,
+		Receiver:  receiver,
+		Content:   content,
+		CreatedAt: createdAt,
+	})
+	return nil
+}
+
+// ListOffline 查询离线私聊
+func (s *MemoryStore) ListOffline(receiver string) ([]OfflineMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []OfflineMessage
+	for _, m := range s.offline {
+		if m.Receiver == receiver {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+// DeleteOffline 删除已投递的离线私聊
+func (s *MemoryStore) DeleteOffline(receiver string, lastID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.offline[:0]
+	for _, m := range s.offline {
+		if m.Receiver != receiver || m.Id > lastID {
+			kept = append(kept, m)
+		}
+	}
+	s.offline = kept
+	return nil
+}
+
+// AddSanction 记录禁言或封禁
+func (s *MemoryStore) AddSanction(sanction Sanction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sanctions[sanction.Username+" "+sanction.Kind] = sanction
+	return nil
+}
+
+// ActiveSanction 查询用户当前生效的禁言或封禁
+func (s *MemoryStore) ActiveSanction(username string, kind string, now time.Time) (*Sanction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sanction, ok := s.sanctions[username+" "+kind]
+	if !ok || (sanction.ExpiresAt != nil && !sanction.ExpiresAt.After(now)) {
+		return nil, nil
+	}
+	return &sanction, nil
+}
+
+// RemoveSanction 解除禁言或封禁
+func (s *MemoryStore) RemoveSanction(username string, kind string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := username + " " + kind
+	sanction, ok := s.sanctions[key]
+	delete(s.sanctions, key)
+	return ok && (sanction.ExpiresAt == nil || sanction.ExpiresAt.After(now)), nil
+}
+
+// Append 向房间的流中追加消息
+func (s *MemoryStore) Append(room string, entry StreamEntry) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streamSeq++
+	entry.StreamID = fmt.Sprintf("%d-%d", entry.Time.UnixMilli(), s.streamSeq)
+	stream := append(s.streams[room], entry)
+	if int64(len(stream)) > s.streamMaxLen {
+		stream = stream[int64(len(stream))-s.streamMaxLen:]
+	}
+	s.streams[room] = stream
+	// 唤醒所有等待中的 Read
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return entry.StreamID, nil
+}
+
+// group 返回房间的消费组，不存在时从流的末尾创建，调用方需持有锁
+func (s *MemoryStore) group(room string, name string) *memoryGroup {
+	groups, ok := s.groups[room]
+	if !ok {
+		groups = make(map[string]*memoryGroup)
+		s.groups[room] = groups
+	}
+	g, ok := groups[name]
+	if !ok {
+		g = &memoryGroup{delivered: s.streamSeq, pending: make(map[string]pendingEntry)}
+		groups[name] = g
+	}
+	return g
+}
+
+// CreateGroup 创建消费组
+func (s *MemoryStore) CreateGroup(group string, room string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.group(room, group)
+	return nil
+}
+
+// Read 以消费组读取多个房间的流，没有新消息时最多阻塞 block
+func (s *MemoryStore) Read(group string, consumer string, rooms []string, count int64, block time.Duration) (map[string][]StreamEntry, error) {
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		res := make(map[string][]StreamEntry)
+		for _, room := range rooms {
+			g := s.group(room, group)
+			for _, entry := range s.streams[room] {
+				if int64(len(res[room])) >= count {
+					break
+				}
+				if seq := streamSeq(entry.StreamID); seq > g.delivered {
+					g.delivered = seq
+					g.pending[entry.StreamID] = pendingEntry{consumer: consumer, since: time.Now()}
+					res[room] = append(res[room], entry)
+				}
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		if len(res) > 0 {
+			return res, nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, nil
+		}
+	}
+}
+
+// Pending 读取已读取但未确认的消息
+func (s *MemoryStore) Pending(group string, consumer string, rooms []string, minIdle time.Duration, count int64) (map[string][]StreamEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	res := make(map[string][]StreamEntry)
+	for _, room := range rooms {
+		g := s.group(room, group)
+		for id, p := range g.pending {
+			if p.consumer != consumer && now.Sub(p.since) >= minIdle {
+				g.pending[id] = pendingEntry{consumer: consumer, since: now}
+			}
+		}
+		kept := make(map[string]struct{}, len(g.pending))
+		for _, entry := range s.streams[room] {
+			p, ok := g.pending[entry.StreamID]
+			if !ok || p.consumer != consumer {
+				continue
+			}
+			kept[entry.StreamID] = struct{}{}
+			if int64(len(res[room])) < count {
+				res[room] = append(res[room], entry)
+			}
+		}
+		// 已被裁剪出流的消息无法再处理，直接确认
+		for id, p := range g.pending {
+			if _, ok := kept[id]; !ok && p.consumer == consumer {
+				delete(g.pending, id)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Ack 确认消息已处理
+func (s *MemoryStore) Ack(group string, room string, streamIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.group(room, group)
+	for _, id := range streamIDs {
+		delete(g.pending, id)
+	}
+	return nil
+}
+
+// streamSeq 取流ID中的序号部分
+func streamSeq(streamID string) int64 {
+	_, seq, _ := strings.Cut(streamID, "-")
+	n, _ := strconv.ParseInt(seq, 10, 64)
+	return n
+}
+
+// AddActivity 给用户追加活跃度
+func (s *MemoryStore) AddActivity(username string, number float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rank[username] += number
+	return nil
+}
+
+// ActivityRank 活跃度排名
+func (s *MemoryStore) ActivityRank() ([]RankItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]RankItem, 0, len(s.rank))
+	for username, score := range s.rank {
+		items = append(items, RankItem{Username: username, Score: score})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return items[i].Username < items[j].Username
+	})
+	return items, nil
+}
+
+// online 用户的在线记录是否有效，调用方需持有锁
+func (s *MemoryStore) online(username string) (presence, bool) {
+	p, ok := s.presence[username]
+	if !ok || time.Now().After(p.expires) {
+		return presence{}, false
+	}
+	return p, true
+}
+
+// Claim 标记用户在 node 上线
+func (s *MemoryStore) Claim(username string, node string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.online(username); ok {
+		return false, nil
+	}
+	s.presence[username] = presence{node: node, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+// Refresh 续期在线状态
+func (s *MemoryStore) Refresh(username string, node string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.online(username); ok && p.node != node {
+		return nil
+	}
+	s.presence[username] = presence{node: node, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release 标记下线
+func (s *MemoryStore) Release(username string, node string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.presence[username]; ok && p.node == node {
+		delete(s.presence, username)
+	}
+	return nil
+}
+
+// IsOnline 用户是否在线
+func (s *MemoryStore) IsOnline(username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.online(username)
+	return ok, nil
+}
+
+// Online 所有在线用户
+func (s *MemoryStore) Online() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []string
+	for username := range s.presence {
+		if _, ok := s.online(username); ok {
+			users = append(users, username)
+		}
+	}
+	return users, nil
+}
+
+// AddRoom 创建房间
+func (s *MemoryStore) AddRoom(name string, owner string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[name]; ok {
+		return false, nil
+	}
+	s.rooms[name] = owner
+	return true, nil
+}
+
+// Rooms 所有房间
+func (s *MemoryStore) Rooms() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms := make(map[string]string, len(s.rooms))
+	for name, owner := range s.rooms {
+		rooms[name] = owner
+	}
+	return rooms, nil
+}
+
+// SaveMemberRooms 记下用户断线时所在的房间
+func (s *MemoryStore) SaveMemberRooms(username string, rooms []string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(rooms) == 0 {
+		delete(s.memberRooms, username)
+		return nil
+	}
+	s.memberRooms[username] = memberRooms{rooms: slices.Clone(rooms), expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// MemberRooms 用户断线时所在的房间
+func (s *MemoryStore) MemberRooms(username string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.memberRooms[username]
+	if !ok || !time.Now().Before(stored.expires) {
+		delete(s.memberRooms, username)
+		return nil, nil
+	}
+	return slices.Clone(stored.rooms), nil
+}
+
+// SaveSession 保存会话
+func (s *MemoryStore) SaveSession(session Session, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session.Rooms = slices.Clone(session.Rooms)
+	s.sessions[session.Token] = memorySession{session: session, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// GetSession 查询会话
+func (s *MemoryStore) GetSession(token string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.sessions[token]
+	if !ok || !time.Now().Before(stored.expires) {
+		delete(s.sessions, token)
+		return nil, ErrSessionNotFound
+	}
+	session := stored.session
+	session.Rooms = slices.Clone(session.Rooms)
+	return &session, nil
+}
+
+// DeleteSession 注销会话
+func (s *MemoryStore) DeleteSession(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+	return nil
+}
+
+// AddFile 登记一次上传
+func (s *MemoryStore) AddFile(file File) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fileID++
+	file.Id = s.fileID
+	s.files[file.Id] = file
+	return file.Id, nil
+}
+
+// GetFile 按ID查询文件
+func (s *MemoryStore) GetFile(id int64) (*File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.files[id]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	return &file, nil
+}
+
+// FindUpload 查找尚未完成的同一文件上传
+func (s *MemoryStore) FindUpload(owner string, room string, receiver string, checksum string) (*File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found *File
+	for _, file := range s.files {
+		if file.Owner == owner && file.Room == room && file.Receiver == receiver && file.Checksum == checksum &&
+			file.CompletedAt == nil && (found == nil || file.Id > found.Id) {
+			found = &file
+		}
+	}
+	return found, nil
+}
+
+// CompleteFile 标记上传完成
+func (s *MemoryStore) CompleteFile(id int64, completedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if file, ok := s.files[id]; ok {
+		file.CompletedAt = &completedAt
+		s.files[id] = file
+	}
+	return nil
+}
+
+// DeleteFile 删除登记
+func (s *MemoryStore) DeleteFile(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, id)
+	return nil
+}
+
+// UsedSpace 用户已占用的空间
+func (s *MemoryStore) UsedSpace(owner string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var used int64
+	for _, file := range s.files {
+		if file.Owner == owner {
+			used += file.Size
+		}
+	}
+	return used, nil
+}
+
+// StaleUploads 长时间未完成的上传
+func (s *MemoryStore) StaleUploads(owner string, before time.Time) ([]File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []File
+	for _, file := range s.files {
+		if file.Owner == owner && file.CompletedAt == nil && file.CreatedAt.Before(before) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// AddReaction 回应表情
+func (s *MemoryStore) AddReaction(messageID int64, emoji string, username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	member := emoji + " " + username
+	if slices.Contains(s.reactions[messageID], member) {
+		return false, nil
+	}
+	s.reactions[messageID] = append(s.reactions[messageID], member)
+	return true, nil
+}
+
+// RemoveReaction 取消回应
+func (s *MemoryStore) RemoveReaction(messageID int64, emoji string, username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := s.reactions[messageID]
+	i := slices.Index(members, emoji+" "+username)
+	if i < 0 {
+		return false, nil
+	}
+	s.reactions[messageID] = slices.Delete(members, i, i+1)
+	return true, nil
+}
+
+// Reactions 查询多条消息的回应
+func (s *MemoryStore) Reactions(messageIDs []int64) (map[int64][]Reaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[int64][]Reaction)
+	for _, id := range messageIDs {
+		if reactions := groupReactions(s.reactions[id]); len(reactions) > 0 {
+			res[id] = reactions
+		}
+	}
+	return res, nil
+}
+
+// PublishEvent 发给所有订阅者，订阅者的通道满了就丢弃
+func (s *MemoryStore) PublishEvent(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, events := range s.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+// SubscribeEvents 订阅即时事件
+func (s *MemoryStore) SubscribeEvents() (<-chan Event, error) {
+	events := make(chan Event, eventBuffer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, events)
+	return events, nil
+}
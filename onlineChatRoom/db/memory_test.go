@@ -0,0 +1,130 @@
+package db
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// appendN 向房间的流中追加 n 条消息，消息ID从 from 开始
+func appendN(t *testing.T, s *MemoryStore, room string, from int, n int) {
+	t.Helper()
+	for i := from; i < from+n; i++ {
+		if _, err := s.Append(room, StreamEntry{ID: int64(i), Sender: "alice", Content: "hi", Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// idsOf 流中消息的ID
+func idsOf(entries []StreamEntry) []int64 {
+	ids := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+func TestMemoryStoreRead(t *testing.T) {
+	tests := []struct {
+		name   string
+		before int   // 创建消费组之前写入的条数
+		after  int   // 创建消费组之后写入的条数
+		count  int64 // 每次最多读取的条数
+		want   [][]int64
+	}{
+		{"消费组从流的末尾开始读", 2, 3, 10, [][]int64{{3, 4, 5}, nil}},
+		{"分批读取", 0, 3, 2, [][]int64{{1, 2}, {3}, nil}},
+		{"没有新消息", 3, 0, 10, [][]int64{nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore(100)
+			appendN(t, s, "lobby", 1, tt.before)
+			if err := s.CreateGroup("node1", "lobby"); err != nil {
+				t.Fatal(err)
+			}
+			appendN(t, s, "lobby", tt.before+1, tt.after)
+			for i, want := range tt.want {
+				streams, err := s.Read("node1", "node1", []string{"lobby"}, tt.count, 10*time.Millisecond)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := idsOf(streams["lobby"]); !slices.Equal(got, want) {
+					t.Fatalf("第 %d 次读取 = %v，应为 %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoreReadWakeUp(t *testing.T) {
+	s := NewMemoryStore(100)
+	if err := s.CreateGroup("node1", "lobby"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		appendN(t, s, "lobby", 1, 1)
+	}()
+	start := time.Now()
+	streams, err := s.Read("node1", "node1", []string{"lobby"}, 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams["lobby"]) != 1 || time.Since(start) >= time.Second {
+		t.Fatalf("阻塞读取应在有新消息时返回，读到 %v", idsOf(streams["lobby"]))
+	}
+}
+
+func TestMemoryStorePending(t *testing.T) {
+	tests := []struct {
+		name     string
+		ack      []int  // 读取后确认的消息序号(从 0 开始)
+		consumer string // 查询未确认消息的消费者
+		minIdle  time.Duration
+		more     int // 读取之后再写入的条数，超过流的长度上限时最早的消息被裁剪
+		want     []int64
+		left     []int64 // 之后原消费者仍未确认的消息
+	}{
+		{"未确认的消息", nil, "c1", time.Hour, 0, []int64{1, 2, 3}, []int64{1, 2, 3}},
+		{"确认后不再返回", []int{0, 2}, "c1", time.Hour, 0, []int64{2}, []int64{2}},
+		{"其他消费者闲置不够久不认领", nil, "c2", time.Hour, 0, nil, []int64{1, 2, 3}},
+		{"认领其他消费者闲置的消息", []int{1}, "c2", 0, 0, []int64{1, 3}, nil},
+		{"已被裁剪出流的直接确认", nil, "c1", time.Hour, 2, []int64{3}, []int64{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore(3)
+			if err := s.CreateGroup("node1", "lobby"); err != nil {
+				t.Fatal(err)
+			}
+			appendN(t, s, "lobby", 1, 3)
+			streams, err := s.Read("node1", "c1", []string{"lobby"}, 10, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := streams["lobby"]
+			for _, i := range tt.ack {
+				if err = s.Ack("node1", "lobby", entries[i].StreamID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			appendN(t, s, "lobby", 4, tt.more)
+			pending, err := s.Pending("node1", tt.consumer, []string{"lobby"}, tt.minIdle, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := idsOf(pending["lobby"]); !slices.Equal(got, tt.want) {
+				t.Fatalf("%s 未确认的消息 = %v，应为 %v", tt.consumer, got, tt.want)
+			}
+			left, err := s.Pending("node1", "c1", []string{"lobby"}, time.Hour, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := idsOf(left["lobby"]); !slices.Equal(got, tt.left) {
+				t.Fatalf("c1 未确认的消息 = %v，应为 %v", got, tt.left)
+			}
+		})
+	}
+}
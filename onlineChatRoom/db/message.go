@@ -0,0 +1,344 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// messageColumns messages 表查询的列
+const messageColumns = "id,stream_id,room,sender,receiver,content,parent_id,thread_id,created_at,edited_at,recalled_by,delivered_at,read_at"
+
+// SaveMessage 归档一条群聊或私聊消息，返回的自增ID即消息ID
+func (s *MySQLStore) SaveMessage(m ArchivedMessage) (id int64, err error) {
+	sqlStr := "insert into messages(stream_id,room,sender,receiver,content,parent_id,thread_id,created_at) values ('',?,?,?,?,?,?,?)"
+	res, err := s.db.Exec(sqlStr, m.Room, m.Sender, m.Receiver, m.Content, m.ParentId, m.ThreadId, m.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("SaveMessage failed:%w", err)
+	}
+	id, err = res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("SaveMessage failed:%w", err)
+	}
+	return id, nil
+}
+
+// UpdateStreamID 写入streams流后回填流ID
+func (s *MySQLStore) UpdateStreamID(id int64, streamID string) (err error) {
+	sqlStr := "update messages set stream_id = ? where id = ?"
+	_, err = s.db.Exec(sqlStr, streamID, id)
+	if err != nil {
+		return fmt.Errorf("UpdateStreamID failed:%w", err)
+	}
+	return nil
+}
+
+// DeleteMessage 删除写入streams流失败的归档
+func (s *MySQLStore) DeleteMessage(id int64) (err error) {
+	sqlStr := "delete from messages where id = ?"
+	_, err = s.db.Exec(sqlStr, id)
+	if err != nil {
+		return fmt.Errorf("DeleteMessage failed:%w", err)
+	}
+	return nil
+}
+
+// GetMessage 按ID查询归档
+func (s *MySQLStore) GetMessage(id int64) (*ArchivedMessage, error) {
+	var m ArchivedMessage
+	sqlStr := "select " + messageColumns + " from messages where id = ?"
+	err := s.db.Get(&m, sqlStr, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, fmt.Errorf("GetMessage failed:%w", err)
+	}
+	return &m, nil
+}
+
+// EditMessage 修改消息内容，还没投递的离线私聊一并修改
+func (s *MySQLStore) EditMessage(id int64, content string, editedAt time.Time) (err error) {
+	sqlStr := "update messages set content = ?, edited_at = ? where id = ?"
+	_, err = s.db.Exec(sqlStr, content, editedAt, id)
+	if err != nil {
+		return fmt.Errorf("EditMessage failed:%w", err)
+	}
+	sqlStr = "update offline_messages set content = ? where message_id = ?"
+	_, err = s.db.Exec(sqlStr, content, id)
+	if err != nil {
+		return fmt.Errorf("EditMessage failed:%w", err)
+	}
+	return nil
+}
+
+// RecallMessage 撤回或删除消息，清空内容，还没投递的离线私聊不再投递
+func (s *MySQLStore) RecallMessage(id int64, by string) (err error) {
+	sqlStr := "update messages set content = '', recalled_by = ? where id = ?"
+	_, err = s.db.Exec(sqlStr, by, id)
+	if err != nil {
+		return fmt.Errorf("RecallMessage failed:%w", err)
+	}
+	sqlStr = "delete from offline_messages where message_id = ?"
+	_, err = s.db.Exec(sqlStr, id)
+	if err != nil {
+		return fmt.Errorf("RecallMessage failed:%w", err)
+	}
+	return nil
+}
+
+// Thread 查询话题中最新的回复
+func (s *MySQLStore) Thread(threadID int64, limit int64) ([]ArchivedMessage, error) {
+	var res []ArchivedMessage
+	sqlStr := "select " + messageColumns + " from messages where thread_id = ? order by id desc limit ?"
+	err := s.db.Select(&res, sqlStr, threadID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Thread failed:%w", err)
+	}
+	// 按时间正序返回
+	slices.Reverse(res)
+	return res, nil
+}
+
+// History 从归档中查看房间最近的历史消息,limit 限制条数
+func (s *MySQLStore) History(room string, beforeID int64, limit int64) ([]ArchivedMessage, error) {
+	var res []ArchivedMessage
+	if beforeID == 0 {
+		beforeID = math.MaxInt64
+	}
+	sqlStr := "select " + messageColumns + " from messages where room = ? and receiver = '' and id < ? order by id desc limit ?"
+	err := s.db.Select(&res, sqlStr, room, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("History failed:%w", err)
+	}
+	// 按时间正序返回
+	slices.Reverse(res)
+	return res, nil
+}
+
+// Conversation 查询两个用户之间的私聊
+func (s *MySQLStore) Conversation(username string, other string, beforeID int64, limit int64) ([]ArchivedMessage, error) {
+	var res []ArchivedMessage
+	if beforeID == 0 {
+		beforeID = math.MaxInt64
+	}
+	sqlStr := "select " + messageColumns + " from messages " +
+		"where ((sender = ? and receiver = ?) or (sender = ? and receiver = ?)) and id < ? order by id desc limit ?"
+	err := s.db.Select(&res, sqlStr, username, other, other, username, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Conversation failed:%w", err)
+	}
+	// 按时间正序返回
+	slices.Reverse(res)
+	return res, nil
+}
+
+// Search 搜索消息，关键词用 LIKE 匹配，中文不需要分词
+func (s *MySQLStore) Search(q SearchQuery) ([]ArchivedMessage, int64, error) {
+	// 房间列表为空时 in () 不合法，只搜私聊
+	rooms := q.Rooms
+	if len(rooms) == 0 {
+		rooms = []string{""}
+	}
+	where := "recalled_by = '' and ((receiver = '' and room in (?)) or (receiver <> '' and (sender = ? or receiver = ?)))"
+	args := []any{rooms, q.Username, q.Username}
+	// 先用全文索引缩小范围，再由 LIKE 按字面匹配，避免扫描整个归档
+	if against := fulltextQuery(q.Keywords); against != "" {
+		where += " and match(content) against(? in boolean mode)"
+		args = append(args, against)
+	}
+	for _, keyword := range q.Keywords {
+		where += " and content like ?"
+		args = append(args, "%"+likeEscaper.Replace(keyword)+"%")
+	}
+	if q.Sender != "" {
+		where += " and sender = ?"
+		args = append(args, q.Sender)
+	}
+	if q.Room != "" {
+		where += " and room = ? and receiver = ''"
+		args = append(args, q.Room)
+	}
+	if q.With != "" {
+		where += " and ((sender = ? and receiver = ?) or (sender = ? and receiver = ?))"
+		args = append(args, q.Username, q.With, q.With, q.Username)
+	}
+	if !q.Since.IsZero() {
+		where += " and created_at >= ?"
+		args = append(args, q.Since)
+	}
+	if !q.Until.IsZero() {
+		where += " and created_at < ?"
+		args = append(args, q.Until)
+	}
+	where, args, err := sqlx.In(where, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Search failed:%w", err)
+	}
+	var total int64
+	err = s.db.Get(&total, s.db.Rebind("select count(*) from messages where "+where), args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Search failed:%w", err)
+	}
+	var res []ArchivedMessage
+	sqlStr := "select " + messageColumns + " from messages where " + where + " order by id desc limit ? offset ?"
+	err = s.db.Select(&res, s.db.Rebind(sqlStr), append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Search failed:%w", err)
+	}
+	return res, total, nil
+}
+
+// likeEscaper 转义 LIKE 的通配符，关键词按字面匹配
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// minFulltextKeyword ngram 全文索引的分词长度(ngram_token_size 默认为 2)，更短的关键词只能靠 LIKE
+const minFulltextKeyword = 2
+
+// fulltextQuery 把关键词转成布尔模式的全文检索条件，每个关键词作为必须出现的短语
+// 只用全部由字母和数字组成的关键词，带标点的交给 LIKE，免得和全文检索的运算符冲突
+func fulltextQuery(keywords []string) string {
+	var terms []string
+	for _, keyword := range keywords {
+		if utf8.RuneCountInString(keyword) < minFulltextKeyword || strings.IndexFunc(keyword, isNotWordRune) >= 0 {
+			continue
+		}
+		terms = append(terms, `+"`+keyword+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// isNotWordRune 不是字母或数字
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Missed 查询用户断线期间错过的群聊和私聊
+func (s *MySQLStore) Missed(username string, rooms []string, afterID int64, since time.Time, limit int64) ([]ArchivedMessage, error) {
+	var res []ArchivedMessage
+	// 房间列表为空时 in () 不合法，只查私聊
+	if len(rooms) == 0 {
+		rooms = []string{""}
+	}
+	sqlStr, args, err := sqlx.In("select "+messageColumns+" from messages "+
+		"where id > ? and created_at >= ? and ((receiver = '' and sender <> ? and room in (?)) or receiver = ?) "+
+		"order by id desc limit ?", afterID, since, username, rooms, username, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Missed failed:%w", err)
+	}
+	err = s.db.Select(&res, s.db.Rebind(sqlStr), args...)
+	if err != nil {
+		return nil, fmt.Errorf("Missed failed:%w", err)
+	}
+	// 按时间正序返回
+	slices.Reverse(res)
+	return res, nil
+}
+
+// MarkDelivered 记下私聊送达的时间
+func (s *MySQLStore) MarkDelivered(id int64, at time.Time) (err error) {
+	sqlStr := "update messages set delivered_at = ? where id = ? and delivered_at is null"
+	_, err = s.db.Exec(sqlStr, at, id)
+	if err != nil {
+		return fmt.Errorf("MarkDelivered failed:%w", err)
+	}
+	return nil
+}
+
+// MarkRead 记下私聊已读的时间，读到的一定已经送达
+func (s *MySQLStore) MarkRead(id int64, at time.Time) (bool, error) {
+	sqlStr := "update messages set read_at = ?, delivered_at = coalesce(delivered_at, ?) where id = ? and read_at is null"
+	res, err := s.db.Exec(sqlStr, at, at, id)
+	if err != nil {
+		return false, fmt.Errorf("MarkRead failed:%w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("MarkRead failed:%w", err)
+	}
+	return n > 0, nil
+}
+
+// SentPrivate 查询用户最近发出的私聊
+func (s *MySQLStore) SentPrivate(sender string, limit int64) ([]ArchivedMessage, error) {
+	var res []ArchivedMessage
+	sqlStr := "select " + messageColumns + " from messages where sender = ? and receiver <> '' order by id desc limit ?"
+	err := s.db.Select(&res, sqlStr, sender, limit)
+	if err != nil {
+		return nil, fmt.Errorf("SentPrivate failed:%w", err)
+	}
+	// 按时间正序返回
+	slices.Reverse(res)
+	return res, nil
+}
+
+// AddMention 存入提醒收件箱
+func (s *MySQLStore) AddMention(username string, messageID int64) (err error) {
+	sqlStr := "insert into mentions(username,message_id) values (?,?)"
+	_, err = s.db.Exec(sqlStr, username, messageID)
+	if err != nil {
+		return fmt.Errorf("AddMention failed:%w", err)
+	}
+	return nil
+}
+
+// Mentions 查询收件箱中提到该用户的消息
+func (s *MySQLStore) Mentions(username string, limit int64) ([]ArchivedMessage, error) {
+	var res []ArchivedMessage
+	sqlStr := "select " + messageColumns + " from messages where id in (select message_id from mentions where username = ?) order by id desc limit ?"
+	err := s.db.Select(&res, sqlStr, username, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Mentions failed:%w", err)
+	}
+	// 按时间正序返回
+	slices.Reverse(res)
+	return res, nil
+}
+
+// DeleteMentions 清除已展示的提醒
+func (s *MySQLStore) DeleteMentions(username string, lastID int64) (err error) {
+	sqlStr := "delete from mentions where username = ? and message_id <= ?"
+	_, err = s.db.Exec(sqlStr, username, lastID)
+	if err != nil {
+		return fmt.Errorf("DeleteMentions failed:%w", err)
+	}
+	return nil
+}
+
+// AddOffline 暂存一条离线私聊
+func (s *MySQLStore) AddOffline(messageID int64, sender string, receiver string, content string, createdAt time.Time) (err error) {
+	sqlStr := "insert into offline_messages(message_id,sender,receiver,content,created_at) values (?,?,?,?,?)"
+	_, err = s.db.Exec(sqlStr, messageID, sender, receiver, content, createdAt)
+	if err != nil {
+		return fmt.Errorf("AddOfflineMessage failed:%w", err)
+	}
+	return nil
+}
+
+// ListOffline 按发送顺序查询用户的离线私聊
+func (s *MySQLStore) ListOffline(receiver string) ([]OfflineMessage, error) {
+	var res []OfflineMessage
+	sqlStr := "select id,message_id,sender,receiver,content,created_at from offline_messages where receiver = ? order by id"
+	err := s.db.Select(&res, sqlStr, receiver)
+	if err != nil {
+		return nil, fmt.Errorf("ListOfflineMessage failed:%w", err)
+	}
+	return res, nil
+}
+
+// DeleteOffline 删除已投递的离线私聊
+func (s *MySQLStore) DeleteOffline(receiver string, lastID int64) (err error) {
+	sqlStr := "delete from offline_messages where receiver = ? and id <= ?"
+	_, err = s.db.Exec(sqlStr, receiver, lastID)
+	if err != nil {
+		return fmt.Errorf("DeleteOfflineMessage failed:%w", err)
+	}
+	return nil
+}
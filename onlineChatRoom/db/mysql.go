@@ -1,9 +1,13 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"onlineChatRoom/config"
 )
 
 var DB *sqlx.DB
@@ -15,33 +19,212 @@ type user struct {
 }
 
 // ConnectDb 连接数据库
-func ConnectDb() (err error) {
-	dsn := "root:995812@tcp(localhost:3306)/onlinechatroom?charset=utf8mb4&parseTime=True&loc=Local"
+func ConnectDb(cfg config.MySQL) (err error) {
 	//连接数据库并尝试ping
-	DB, err = sqlx.Connect("mysql", dsn)
+	DB, err = sqlx.Connect("mysql", cfg.DSN)
 	if err != nil {
 		return fmt.Errorf("connect to mysql failed:%w", err)
 	}
 	return nil
 }
 
-// AddUserDb 注册用户
-func AddUserDb(username string, password string) (err error) {
+// MySQLStore 基于 MySQL 的用户存储和聊天记录归档
+type MySQLStore struct {
+	db *sqlx.DB
+}
+
+func NewMySQLStore(db *sqlx.DB) *MySQLStore {
+	return &MySQLStore{db: db}
+}
+
+// AddUser 注册用户
+func (s *MySQLStore) AddUser(username string, password string) (err error) {
 	sqlStr := "insert into user(username,password) values (?,?)"
-	_, err = DB.Exec(sqlStr, username, password)
+	_, err = s.db.Exec(sqlStr, username, password)
 	if err != nil {
+		// 检查是否是唯一约束冲突（用户名已存在）
+		if isDuplicateKeyError(err) {
+			return ErrUserExists
+		}
 		return fmt.Errorf("AddUser failed:%w", err)
 	}
 	return nil
 }
 
-// SearchUserDb 查询用户
-func SearchUserDb(username string) (pwd string, err error) {
+// isDuplicateKeyError 辅助函数检查是否是唯一约束错误
+func isDuplicateKeyError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062 // 1062是MySQL的重复键问题
+	}
+	return false
+}
+
+// isDuplicateColumnError 检查是否是列已存在的错误
+func isDuplicateColumnError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1060 // 1060是MySQL的重复列问题
+	}
+	return false
+}
+
+// isDuplicateKeyNameError 检查是否是索引已存在的错误
+func isDuplicateKeyNameError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1061 // 1061是MySQL的重复索引名问题
+	}
+	return false
+}
+
+// SearchUser 查询用户
+func (s *MySQLStore) SearchUser(username string) (pwd string, err error) {
 	var u user
 	sqlStr := "select id,username,password from user where username = ?"
-	err = DB.Get(&u, sqlStr, username)
+	err = s.db.Get(&u, sqlStr, username)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrUserNotFound
+		}
 		return "", fmt.Errorf("SearchUser failed:%w", err)
 	}
 	return u.Password, nil
 }
+
+// UpdatePassword 更新用户密码(存入的是哈希)
+func (s *MySQLStore) UpdatePassword(username string, password string) (err error) {
+	sqlStr := "update user set password = ? where username = ?"
+	_, err = s.db.Exec(sqlStr, password, username)
+	if err != nil {
+		return fmt.Errorf("UpdatePassword failed:%w", err)
+	}
+	return nil
+}
+
+// SetReadReceipts 开启或关闭已读回执
+func (s *MySQLStore) SetReadReceipts(username string, enabled bool) (err error) {
+	sqlStr := "insert into user_settings(username,read_receipts) values (?,?) on duplicate key update read_receipts = values(read_receipts)"
+	_, err = s.db.Exec(sqlStr, username, enabled)
+	if err != nil {
+		return fmt.Errorf("SetReadReceipts failed:%w", err)
+	}
+	return nil
+}
+
+// ReadReceipts 查询是否开启了已读回执
+func (s *MySQLStore) ReadReceipts(username string) (bool, error) {
+	var enabled bool
+	sqlStr := "select read_receipts from user_settings where username = ?"
+	err := s.db.Get(&enabled, sqlStr, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil
+		}
+		return false, fmt.Errorf("ReadReceipts failed:%w", err)
+	}
+	return enabled, nil
+}
+
+// migrations 启动时依次执行的表结构调整，每条都必须可以重复执行
+var migrations = []string{
+	// bcrypt 哈希长度为60，旧的 varchar(50) 放不下
+	"alter table user modify password varchar(100) not null",
+	// 聊天记录归档，私聊的 room 为空
+	`create table if not exists messages (
+		id bigint not null auto_increment primary key,
+		stream_id varchar(32) not null,
+		room varchar(20) not null default '',
+		sender varchar(50) not null,
+		receiver varchar(50) not null default '',
+		content text not null,
+		created_at datetime(3) not null,
+		key idx_room (room, id),
+		key idx_receiver (receiver, id),
+		key idx_sender (sender, id)
+	) default charset = utf8mb4`,
+	// 发给离线用户的私聊，投递后删除
+	`create table if not exists offline_messages (
+		id bigint not null auto_increment primary key,
+		sender varchar(50) not null,
+		receiver varchar(50) not null,
+		content text not null,
+		created_at datetime(3) not null,
+		key idx_receiver (receiver, id)
+	) default charset = utf8mb4`,
+	// 管理员的禁言和封禁，expires_at 为空表示永久
+	`create table if not exists sanctions (
+		id bigint not null auto_increment primary key,
+		username varchar(50) not null,
+		kind varchar(10) not null,
+		moderator varchar(50) not null,
+		reason varchar(200) not null default '',
+		created_at datetime(3) not null,
+		expires_at datetime(3) null,
+		unique key uk_username_kind (username, kind)
+	) default charset = utf8mb4`,
+	// 上传文件的元数据，内容存在服务端本地磁盘
+	`create table if not exists files (
+		id bigint not null auto_increment primary key,
+		owner varchar(50) not null,
+		room varchar(20) not null default '',
+		receiver varchar(50) not null default '',
+		name varchar(255) not null,
+		size bigint not null,
+		checksum char(64) not null,
+		created_at datetime(3) not null,
+		completed_at datetime(3) null,
+		key idx_owner (owner, checksum)
+	) default charset = utf8mb4`,
+	// 消息的编辑和撤回，重复执行时列已存在的错误被忽略
+	"alter table messages add column edited_at datetime(3) null, add column recalled_by varchar(50) not null default ''",
+	// 离线私聊关联归档的消息，编辑和撤回时一并修改
+	"alter table offline_messages add column message_id bigint not null default 0, add key idx_message (message_id)",
+	// 回复和话题，话题ID是话题第一条消息的ID
+	"alter table messages add column parent_id bigint not null default 0, add column thread_id bigint not null default 0, add key idx_thread (thread_id, id)",
+	// 群聊中提到不在线用户的提醒，下次登录时展示后删除
+	`create table if not exists mentions (
+		id bigint not null auto_increment primary key,
+		username varchar(50) not null,
+		message_id bigint not null,
+		key idx_username (username, message_id)
+	) default charset = utf8mb4`,
+	// 私聊的送达和已读时间
+	"alter table messages add column delivered_at datetime(3) null, add column read_at datetime(3) null",
+	// 用户的个人设置，没有记录的用户使用默认值
+	`create table if not exists user_settings (
+		username varchar(50) not null primary key,
+		read_receipts tinyint(1) not null default 1
+	) default charset = utf8mb4`,
+}
+
+// MigrateDb 启动时调整表结构
+func MigrateDb() (err error) {
+	for _, sqlStr := range migrations {
+		_, err = DB.Exec(sqlStr)
+		if err != nil && !isDuplicateColumnError(err) {
+			return fmt.Errorf("MigrateDb failed:%w", err)
+		}
+	}
+	return addContentIndex()
+}
+
+// addContentIndex 给消息内容加 ngram 全文索引，搜索时先用它缩小范围，重复执行时索引已存在的错误被忽略
+// 建索引时关闭停用词，否则含有 is、the 等停用词的 ngram 不进索引，英文关键词会搜不到
+// 停用词开关是会话级的，必须和建索引使用同一个连接
+func addContentIndex() error {
+	ctx := context.Background()
+	conn, err := DB.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("MigrateDb failed:%w", err)
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "set session innodb_ft_enable_stopword = 0"); err != nil {
+		return fmt.Errorf("MigrateDb failed:%w", err)
+	}
+	_, err = conn.ExecContext(ctx, "alter table messages add fulltext key ft_content (content) with parser ngram")
+	if err != nil && !isDuplicateKeyNameError(err) {
+		return fmt.Errorf("MigrateDb failed:%w", err)
+	}
+	return nil
+}
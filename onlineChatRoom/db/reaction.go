@@ -0,0 +1,74 @@
+package db
+
+import (
+	"fmt"
+	"github.com/go-redis/redis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reactionKey 消息的表情回应，有序集合，成员为 "表情 用户名"，分数为回应时间，按先后展示
+func reactionKey(messageID int64) string {
+	return "reactions:" + strconv.FormatInt(messageID, 10)
+}
+
+// AddReaction 回应表情，已经回应过返回 false
+func (s *RedisStore) AddReaction(messageID int64, emoji string, username string) (bool, error) {
+	n, err := s.rdb.ZAddNX(reactionKey(messageID), redis.Z{
+		Score:  float64(time.Now().UnixMilli()),
+		Member: emoji + " " + username,
+	}).Result()
+	if err != nil {
+		return false, fmt.Errorf("rdb.ZAddNX failed:%w", err)
+	}
+	return n == 1, nil
+}
+
+// RemoveReaction 取消回应，没有回应过返回 false
+func (s *RedisStore) RemoveReaction(messageID int64, emoji string, username string) (bool, error) {
+	n, err := s.rdb.ZRem(reactionKey(messageID), emoji+" "+username).Result()
+	if err != nil {
+		return false, fmt.Errorf("rdb.ZRem failed:%w", err)
+	}
+	return n == 1, nil
+}
+
+// Reactions 一次查询多条消息的回应
+func (s *RedisStore) Reactions(messageIDs []int64) (map[int64][]Reaction, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(messageIDs))
+	for i, id := range messageIDs {
+		cmds[i] = pipe.ZRange(reactionKey(id), 0, -1)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return nil, fmt.Errorf("Reactions failed:%w", err)
+	}
+	res := make(map[int64][]Reaction)
+	for i, cmd := range cmds {
+		if reactions := groupReactions(cmd.Val()); len(reactions) > 0 {
+			res[messageIDs[i]] = reactions
+		}
+	}
+	return res, nil
+}
+
+// groupReactions 把按先后排列的 "表情 用户名" 按表情汇总，表情按第一次出现的先后排列
+func groupReactions(members []string) []Reaction {
+	var res []Reaction
+	index := make(map[string]int)
+	for _, member := range members {
+		emoji, username, _ := strings.Cut(member, " ")
+		i, ok := index[emoji]
+		if !ok {
+			i = len(res)
+			index[emoji] = i
+			res = append(res, Reaction{Emoji: emoji})
+		}
+		res[i].Users = append(res[i].Users, username)
+	}
+	return res
+}
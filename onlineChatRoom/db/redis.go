@@ -1,22 +1,27 @@
 package db
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis"
 	"log"
+	"onlineChatRoom/config"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var RDB *redis.Client
 
 // InitRedis 连接Redis
-func InitRedis() error {
+func InitRedis(cfg config.Redis) error {
 	RDB = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-		PoolSize: 100, //连接池的大小
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+		PoolSize: cfg.PoolSize, //连接池的大小
 	})
 	_, err := RDB.Ping().Result()
 	if err != nil {
@@ -25,42 +30,60 @@ func InitRedis() error {
 	return nil
 }
 
+// RedisStore 基于 Redis 的房间消息流、活跃度排行、在线状态、房间列表和登录会话
+type RedisStore struct {
+	rdb          *redis.Client
+	streamMaxLen int64    // streams流的最大长度，超出自动清除
+	groups       sync.Map // 已确认存在的消费组
+}
+
+func NewRedisStore(rdb *redis.Client, streamMaxLen int64) *RedisStore {
+	return &RedisStore{rdb: rdb, streamMaxLen: streamMaxLen}
+}
+
 // AddActivity 给用户追加活跃度
-func AddActivity(username string, number float64) error {
-	err := RDB.ZIncrBy("activityRank", number, username).Err()
+func (s *RedisStore) AddActivity(username string, number float64) error {
+	err := s.rdb.ZIncrBy("activityRank", number, username).Err()
 	if err != nil {
 		return fmt.Errorf("rdb.ZIncrBy failed:%w", err)
 	}
 	return nil
 }
 
-// ShowActivityRank 显示活跃度排名
-func ShowActivityRank() (string, error) {
+// ActivityRank 活跃度排名
+func (s *RedisStore) ActivityRank() ([]RankItem, error) {
 	// zSlice 是一个结构体，存放排名信息
-	zSlice, err := RDB.ZRevRangeWithScores("activityRank", 0, -1).Result()
+	zSlice, err := s.rdb.ZRevRangeWithScores("activityRank", 0, -1).Result()
 	if err != nil {
-		return "", fmt.Errorf("rdb.ZRevRangeWithScores failed:%w", err)
+		return nil, fmt.Errorf("rdb.ZRevRangeWithScores failed:%w", err)
 	}
-	var sprintf string
-	for i, value := range zSlice {
-		if value.Member.(string) == "系统广播" {
-			continue
-		}
-		// 显示排名、名字和分数、排名从 1 开始
-		sprintf = fmt.Sprintf("%s排名 %d: %s\t, 活跃度=%d\n", sprintf, i+1, value.Member.(string), int(value.Score))
+	items := make([]RankItem, 0, len(zSlice))
+	for _, value := range zSlice {
+		member, _ := value.Member.(string)
+		items = append(items, RankItem{Username: member, Score: value.Score})
 	}
-	return strings.Trim(sprintf, "\n"), nil
+	return items, nil
+}
+
+// StreamKey 房间对应的streams流
+func StreamKey(room string) string {
+	return "room:" + room
 }
 
-// AddStreamsData 向streams流中添加数据
-func AddStreamsData(username string, content string, receiver string) (string, error) {
-	msgID, err := RDB.XAdd(&redis.XAddArgs{
-		Stream: "room", // 接收都用这一个streams流
-		MaxLen: 100,    // 限制最大消息长度，超出自动清除
+// Append 向房间的streams流中添加数据
+func (s *RedisStore) Append(room string, entry StreamEntry) (string, error) {
+	msgID, err := s.rdb.XAdd(&redis.XAddArgs{
+		Stream: StreamKey(room), // 每个房间一个streams流
+		MaxLen: s.streamMaxLen,  // 限制最大消息长度，超出自动清除
 		Values: map[string]interface{}{
-			"sender":   username,
-			"content":  content,
-			"receiver": receiver,
+			"id":       entry.ID,
+			"action":   entry.Action,
+			"sender":   entry.Sender,
+			"content":  entry.Content,
+			"receiver": entry.Receiver,
+			"parent":   entry.ParentID,
+			"quote":    entry.Quote,
+			"time":     entry.Time.UnixMilli(),
 		},
 	}).Result()
 	if err != nil {
@@ -69,39 +92,382 @@ func AddStreamsData(username string, content string, receiver string) (string, e
 	return msgID, nil
 }
 
-// ReadStreams 读取流消息
-func ReadStreams(count int64, method string) ([]redis.XMessage, error) {
-	result, err := RDB.XRead(&redis.XReadArgs{
-		Streams: []string{"room", method},
-		Count:   count,
-		Block:   0,
+// CreateGroup 创建消费组，从streams流的末尾开始读，已存在的组保留原来的读取位置
+func (s *RedisStore) CreateGroup(group string, room string) error {
+	key := StreamKey(room) + " " + group
+	if _, ok := s.groups.Load(key); ok {
+		return nil
+	}
+	err := s.rdb.XGroupCreateMkStream(StreamKey(room), group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("rdb.XGroupCreateMkStream failed:%w", err)
+	}
+	s.groups.Store(key, struct{}{})
+	return nil
+}
+
+// Read 以消费组同时读取多个房间的streams流
+func (s *RedisStore) Read(group string, consumer string, rooms []string, count int64, block time.Duration) (map[string][]StreamEntry, error) {
+	entries, _, err := s.readGroup(group, consumer, rooms, ">", count, block)
+	return entries, err
+}
+
+// Pending 读取已读取但未确认的消息
+func (s *RedisStore) Pending(group string, consumer string, rooms []string, minIdle time.Duration, count int64) (map[string][]StreamEntry, error) {
+	for _, room := range rooms {
+		if err := s.CreateGroup(group, room); err != nil {
+			return nil, err
+		}
+		if err := s.claim(room, group, consumer, minIdle, count); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		entries, n, err := s.readGroup(group, consumer, rooms, "0", count, -1)
+		// 读到的全是已被裁剪的消息时，这些消息已经确认掉了，继续读后面的
+		if err != nil || len(entries) > 0 || n == 0 {
+			return entries, err
+		}
+	}
+}
+
+// claim 把组内其他消费者闲置超过 minIdle 的消息认领到 consumer 名下
+func (s *RedisStore) claim(room string, group string, consumer string, minIdle time.Duration, count int64) error {
+	pending, err := s.rdb.XPendingExt(&redis.XPendingExtArgs{
+		Stream: StreamKey(room),
+		Group:  group,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("rdb.XPendingExt failed:%w", err)
+	}
+	var ids []string
+	for _, p := range pending {
+		if p.Consumer != consumer && p.Idle >= minIdle {
+			ids = append(ids, p.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	err = s.rdb.XClaimJustID(&redis.XClaimArgs{
+		Stream:   StreamKey(room),
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("rdb.XClaim failed:%w", err)
+	}
+	return nil
+}
+
+// readGroup 执行 XREADGROUP，id 为 ">" 读新消息，为 "0" 读未确认的消息
+// 返回的数量包含已被裁剪、只剩ID的消息，这类消息直接确认，不返回给调用方
+func (s *RedisStore) readGroup(group string, consumer string, rooms []string, id string, count int64, block time.Duration) (map[string][]StreamEntry, int, error) {
+	streams := make([]string, 0, len(rooms)*2)
+	for _, room := range rooms {
+		if err := s.CreateGroup(group, room); err != nil {
+			return nil, 0, err
+		}
+		streams = append(streams, StreamKey(room))
+	}
+	for range rooms {
+		streams = append(streams, id)
+	}
+	result, err := s.rdb.XReadGroup(&redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  streams,
+		Count:    count,
+		Block:    block,
 	}).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, 0, nil
+		}
+		if strings.HasPrefix(err.Error(), "NOGROUP") {
+			// streams流被删除后消费组也没了，下次读取时重新创建
+			for _, room := range rooms {
+				s.groups.Delete(StreamKey(room) + " " + group)
+			}
+		}
+		return nil, 0, fmt.Errorf("XREADGROUP error: %w", err)
+	}
+	entries := make(map[string][]StreamEntry, len(result))
+	n := 0
+	for _, stream := range result {
+		room := strings.TrimPrefix(stream.Stream, StreamKey(""))
+		for _, m := range stream.Messages {
+			n++
+			if len(m.Values) == 0 {
+				if err = s.Ack(group, room, m.ID); err != nil {
+					log.Println(err)
+				}
+				continue
+			}
+			entries[room] = append(entries[room], parseStreamEntry(m))
+		}
+	}
+	return entries, n, nil
+}
+
+// Ack 确认消息已处理
+func (s *RedisStore) Ack(group string, room string, streamIDs ...string) error {
+	if len(streamIDs) == 0 {
+		return nil
+	}
+	err := s.rdb.XAck(StreamKey(room), group, streamIDs...).Err()
+	if err != nil {
+		return fmt.Errorf("rdb.XAck failed:%w", err)
+	}
+	return nil
+}
+
+// parseStreamEntry 解析streams流消息，Redis 返回的字段值都是字符串
+func parseStreamEntry(m redis.XMessage) StreamEntry {
+	entry := StreamEntry{StreamID: m.ID}
+	entry.Action, _ = m.Values["action"].(string)
+	entry.Sender, _ = m.Values["sender"].(string)
+	entry.Receiver, _ = m.Values["receiver"].(string)
+	entry.Content, _ = m.Values["content"].(string)
+	entry.Quote, _ = m.Values["quote"].(string)
+	if id, ok := m.Values["id"].(string); ok {
+		entry.ID, _ = strconv.ParseInt(id, 10, 64)
+	}
+	if parent, ok := m.Values["parent"].(string); ok {
+		entry.ParentID, _ = strconv.ParseInt(parent, 10, 64)
+	}
+	if ms, ok := m.Values["time"].(string); ok {
+		n, _ := strconv.ParseInt(ms, 10, 64)
+		entry.Time = time.UnixMilli(n)
+	}
+	return entry
+}
+
+// presenceKey 用户在线状态，值为所在节点
+func presenceKey(username string) string {
+	return "presence:" + username
+}
+
+// refreshScript 记录不存在或属于该节点时续期
+var refreshScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner == false or owner == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+end
+return false`)
+
+// releaseScript 记录属于该节点时才删除，避免误删用户在其他节点的新登录
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Claim 标记用户在 node 上线
+func (s *RedisStore) Claim(username string, node string, ttl time.Duration) (bool, error) {
+	ok, err := s.rdb.SetNX(presenceKey(username), node, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("rdb.SetNX failed:%w", err)
+	}
+	return ok, nil
+}
+
+// Refresh 续期在线状态
+func (s *RedisStore) Refresh(username string, node string, ttl time.Duration) error {
+	err := refreshScript.Run(s.rdb, []string{presenceKey(username)}, node, ttl.Milliseconds()).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("refresh presence failed:%w", err)
+	}
+	return nil
+}
+
+// Release 标记下线
+func (s *RedisStore) Release(username string, node string) error {
+	err := releaseScript.Run(s.rdb, []string{presenceKey(username)}, node).Err()
+	if err != nil {
+		return fmt.Errorf("release presence failed:%w", err)
+	}
+	return nil
+}
+
+// IsOnline 用户是否在线
+func (s *RedisStore) IsOnline(username string) (bool, error) {
+	n, err := s.rdb.Exists(presenceKey(username)).Result()
+	if err != nil {
+		return false, fmt.Errorf("rdb.Exists failed:%w", err)
+	}
+	return n > 0, nil
+}
+
+// Online 所有在线用户
+func (s *RedisStore) Online() ([]string, error) {
+	var users []string
+	iter := s.rdb.Scan(0, presenceKey("*"), 100).Iterator()
+	for iter.Next() {
+		users = append(users, strings.TrimPrefix(iter.Val(), presenceKey("")))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("rdb.Scan failed:%w", err)
+	}
+	return users, nil
+}
+
+// AddRoom 创建房间
+func (s *RedisStore) AddRoom(name string, owner string) (bool, error) {
+	ok, err := s.rdb.HSetNX("rooms", name, owner).Result()
+	if err != nil {
+		return false, fmt.Errorf("rdb.HSetNX failed:%w", err)
+	}
+	return ok, nil
+}
+
+// Rooms 所有房间
+func (s *RedisStore) Rooms() (map[string]string, error) {
+	rooms, err := s.rdb.HGetAll("rooms").Result()
+	if err != nil {
+		return nil, fmt.Errorf("rdb.HGetAll failed:%w", err)
+	}
+	return rooms, nil
+}
+
+// memberRoomsKey 用户断线时所在的房间，值为 JSON 格式的房间名列表
+func memberRoomsKey(username string) string {
+	return "memberRooms:" + username
+}
+
+// SaveMemberRooms 记下用户断线时所在的房间
+func (s *RedisStore) SaveMemberRooms(username string, rooms []string, ttl time.Duration) error {
+	if len(rooms) == 0 {
+		if err := s.rdb.Del(memberRoomsKey(username)).Err(); err != nil {
+			return fmt.Errorf("rdb.Del failed:%w", err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(rooms)
+	if err != nil {
+		return fmt.Errorf("SaveMemberRooms failed:%w", err)
+	}
+	if err = s.rdb.Set(memberRoomsKey(username), data, ttl).Err(); err != nil {
+		return fmt.Errorf("rdb.Set failed:%w", err)
+	}
+	return nil
+}
+
+// MemberRooms 用户断线时所在的房间
+func (s *RedisStore) MemberRooms(username string) ([]string, error) {
+	data, err := s.rdb.Get(memberRoomsKey(username)).Bytes()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return nil, nil
 		}
-		return nil, fmt.Errorf("XREAD error: %w", err)
+		return nil, fmt.Errorf("rdb.Get failed:%w", err)
+	}
+	var rooms []string
+	if err = json.Unmarshal(data, &rooms); err != nil {
+		return nil, fmt.Errorf("MemberRooms failed:%w", err)
+	}
+	return rooms, nil
+}
+
+// sessionKey 登录会话，值为 JSON 格式的 Session
+func sessionKey(token string) string {
+	return "session:" + token
+}
+
+// SaveSession 保存会话并重置有效期
+func (s *RedisStore) SaveSession(session Session, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("SaveSession failed:%w", err)
+	}
+	err = s.rdb.Set(sessionKey(session.Token), data, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("rdb.Set failed:%w", err)
+	}
+	return nil
+}
+
+// GetSession 按令牌查询会话
+func (s *RedisStore) GetSession(token string) (*Session, error) {
+	data, err := s.rdb.Get(sessionKey(token)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("rdb.Get failed:%w", err)
+	}
+	var session Session
+	if err = json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("GetSession failed:%w", err)
+	}
+	return &session, nil
+}
+
+// DeleteSession 注销会话
+func (s *RedisStore) DeleteSession(token string) error {
+	err := s.rdb.Del(sessionKey(token)).Err()
+	if err != nil {
+		return fmt.Errorf("rdb.Del failed:%w", err)
 	}
-	return result[0].Messages, nil
+	return nil
 }
 
-// ShowHistory 查看历史消息,限制只能查看10条历史消息
-func ShowHistory() (string, error) {
-	res, err := RDB.XRevRangeN("room", "+", "-", 10).Result()
+// eventsChannel 即时事件的发布订阅频道
+const eventsChannel = "events"
+
+// PublishEvent 通过 Redis 发布订阅广播事件
+func (s *RedisStore) PublishEvent(event Event) error {
+	data, err := json.Marshal(event)
 	if err != nil {
-		return "", fmt.Errorf("XRevRangeN failed:%w", err)
+		return fmt.Errorf("json.Marshal failed:%w", err)
+	}
+	if err = s.rdb.Publish(eventsChannel, data).Err(); err != nil {
+		return fmt.Errorf("rdb.Publish failed:%w", err)
 	}
-	var history string
-	for i := len(res) - 1; i >= 0; i-- {
-		values := res[i].Values
-		history += fmt.Sprintf("%s: %s\n", values["sender"], values["content"])
+	return nil
+}
+
+// SubscribeEvents 订阅即时事件，连接断开后自动重连，断开期间的事件丢失
+func (s *RedisStore) SubscribeEvents() (<-chan Event, error) {
+	pubsub := s.rdb.Subscribe(eventsChannel)
+	// 等订阅确认后再返回，之后发布的事件都能收到
+	if _, err := pubsub.Receive(); err != nil {
+		_ = pubsub.Close()
+		return nil, fmt.Errorf("rdb.Subscribe failed:%w", err)
 	}
-	return history, nil
+	events := make(chan Event, eventBuffer)
+	go func() {
+		defer close(events)
+		for m := range pubsub.Channel() {
+			var event Event
+			if err := json.Unmarshal([]byte(m.Payload), &event); err != nil {
+				log.Println("解析即时事件失败:", err)
+				continue
+			}
+			select {
+			case events <- event:
+			default:
+			}
+		}
+	}()
+	return events, nil
 }
 
-// ClearRedis 服务端重启时清空活跃度排行和streams流
+// ClearRedis 单节点的服务端重启时清空活跃度排行和在线状态
+// 房间列表、房间的streams流和消费组保留，重启后从上次确认的位置继续处理；登录会话也保留，客户端可以直接恢复并回到原来的房间
+// 表情回应和归档的消息对应，同样保留
+// 集群模式下其他节点仍在使用这些数据，不能调用
 func ClearRedis() {
-	err := RDB.Del("room", "activityRank").Err()
+	keys, err := RDB.Keys(presenceKey("*")).Result()
+	if err != nil {
+		log.Println("查询在线状态失败:", err)
+	}
+	err = RDB.Del(append(keys, "activityRank")...).Err()
 	if err != nil {
 		log.Println("重新开启服务端时清空Redis数据失败:", err)
 	}
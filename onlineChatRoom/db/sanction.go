@@ -0,0 +1,47 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AddSanction 记录禁言或封禁，同一用户同一类型的旧记录被覆盖
+func (s *MySQLStore) AddSanction(sanction Sanction) (err error) {
+	sqlStr := `insert into sanctions(username,kind,moderator,reason,created_at,expires_at) values (?,?,?,?,?,?)
+		on duplicate key update moderator = values(moderator), reason = values(reason), created_at = values(created_at), expires_at = values(expires_at)`
+	_, err = s.db.Exec(sqlStr, sanction.Username, sanction.Kind, sanction.Moderator, sanction.Reason, sanction.CreatedAt, sanction.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("AddSanction failed:%w", err)
+	}
+	return nil
+}
+
+// ActiveSanction 查询用户当前生效的禁言或封禁
+func (s *MySQLStore) ActiveSanction(username string, kind string, now time.Time) (*Sanction, error) {
+	var sanction Sanction
+	sqlStr := "select username,kind,moderator,reason,created_at,expires_at from sanctions where username = ? and kind = ? and (expires_at is null or expires_at > ?)"
+	err := s.db.Get(&sanction, sqlStr, username, kind, now)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ActiveSanction failed:%w", err)
+	}
+	return &sanction, nil
+}
+
+// RemoveSanction 解除禁言或封禁
+func (s *MySQLStore) RemoveSanction(username string, kind string, now time.Time) (bool, error) {
+	sqlStr := "delete from sanctions where username = ? and kind = ? and (expires_at is null or expires_at > ?)"
+	res, err := s.db.Exec(sqlStr, username, kind, now)
+	if err != nil {
+		return false, fmt.Errorf("RemoveSanction failed:%w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("RemoveSanction failed:%w", err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,353 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUserNotFound 用户不存在
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserExists 用户名已被注册
+var ErrUserExists = errors.New("user already exists")
+
+// ErrSessionNotFound 会话不存在或已过期
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrMessageNotFound 消息不存在
+var ErrMessageNotFound = errors.New("message not found")
+
+// ErrFileNotFound 文件不存在
+var ErrFileNotFound = errors.New("file not found")
+
+// UserStore 用户存储
+type UserStore interface {
+	// AddUser 注册用户，用户名已存在返回 ErrUserExists
+	AddUser(username string, password string) error
+	// SearchUser 查询用户的密码(哈希)，用户不存在返回 ErrUserNotFound
+	SearchUser(username string) (string, error)
+	// UpdatePassword 更新用户密码(哈希)
+	UpdatePassword(username string, password string) error
+	// SetReadReceipts 开启或关闭已读回执
+	SetReadReceipts(username string, enabled bool) error
+	// ReadReceipts 用户是否开启了已读回执，没有设置过的默认开启
+	ReadReceipts(username string) (bool, error)
+}
+
+// MessageStore 聊天记录归档和离线私聊
+type MessageStore interface {
+	// SaveMessage 归档一条群聊或私聊消息，返回消息ID，m 的 Id 和 StreamId 不用填
+	SaveMessage(m ArchivedMessage) (int64, error)
+	// UpdateStreamID 写入streams流后回填流ID
+	UpdateStreamID(id int64, streamID string) error
+	// DeleteMessage 删除写入streams流失败的归档
+	DeleteMessage(id int64) error
+	// GetMessage 按ID查询归档，不存在返回 ErrMessageNotFound
+	GetMessage(id int64) (*ArchivedMessage, error)
+	// EditMessage 修改消息内容，还没投递的离线私聊一并修改
+	EditMessage(id int64, content string, editedAt time.Time) error
+	// RecallMessage 撤回或删除消息，清空内容并记下操作者，还没投递的离线私聊不再投递
+	RecallMessage(id int64, by string) error
+	// Thread 话题 threadID 中最新的 limit 条回复，不含话题的第一条消息，按时间正序
+	Thread(threadID int64, limit int64) ([]ArchivedMessage, error)
+	// History 房间中ID小于 beforeID 的最新 limit 条群聊，beforeID 为 0 时不限制，按时间正序
+	History(room string, beforeID int64, limit int64) ([]ArchivedMessage, error)
+	// Conversation 两个用户之间ID小于 beforeID 的最新 limit 条私聊，beforeID 为 0 时不限制，按时间正序
+	Conversation(username string, other string, beforeID int64, limit int64) ([]ArchivedMessage, error)
+	// Missed 用户错过的消息：rooms 中其他人发的群聊和发给该用户的私聊，
+	// ID 大于 afterID 且不早于 since，最多返回最新的 limit 条，按时间正序
+	Missed(username string, rooms []string, afterID int64, since time.Time, limit int64) ([]ArchivedMessage, error)
+	// MarkDelivered 记下私聊送达接收者的时间，已记过的不变
+	MarkDelivered(id int64, at time.Time) error
+	// MarkRead 记下私聊被接收者读到的时间，第一次记下时返回 true
+	MarkRead(id int64, at time.Time) (bool, error)
+	// SentPrivate 用户最近发出的 limit 条私聊，按时间正序
+	SentPrivate(sender string, limit int64) ([]ArchivedMessage, error)
+	// Search 在全部归档中搜索用户能看到的消息，返回按时间倒序的一页和符合条件的总条数
+	Search(q SearchQuery) ([]ArchivedMessage, int64, error)
+	// AddMention 群聊中提到了不在线的用户，存入其提醒收件箱
+	AddMention(username string, messageID int64) error
+	// Mentions 收件箱中最新的 limit 条提到该用户的消息，按时间正序
+	Mentions(username string, limit int64) ([]ArchivedMessage, error)
+	// DeleteMentions 清除收件箱中消息ID不大于 lastID 的提醒
+	DeleteMentions(username string, lastID int64) error
+	// AddOffline 暂存一条离线私聊，messageID 是其归档的消息ID
+	AddOffline(messageID int64, sender string, receiver string, content string, createdAt time.Time) error
+	// ListOffline 按发送顺序查询用户的离线私聊
+	ListOffline(receiver string) ([]OfflineMessage, error)
+	// DeleteOffline 删除已投递的离线私聊
+	DeleteOffline(receiver string, lastID int64) error
+}
+
+// SanctionStore 管理员对用户的禁言和封禁
+type SanctionStore interface {
+	// AddSanction 记录禁言或封禁，同一用户同一类型只保留最新一条
+	AddSanction(sanction Sanction) error
+	// ActiveSanction 用户在 now 时刻生效的禁言或封禁，没有返回 nil
+	ActiveSanction(username string, kind string, now time.Time) (*Sanction, error)
+	// RemoveSanction 提前解除，没有生效中的记录返回 false
+	RemoveSanction(username string, kind string, now time.Time) (bool, error)
+}
+
+// StreamStore 房间消息流，以消费组的方式读取，读到的消息确认之前重启后仍可再次读到
+type StreamStore interface {
+	// Append 向房间的流中追加消息，返回流ID
+	Append(room string, entry StreamEntry) (string, error)
+	// CreateGroup 创建消费组，从流的末尾开始读，已存在时保留原来的读取位置
+	CreateGroup(group string, room string) error
+	// Read 以消费组 group 同时读取多个房间的新消息，组不存在时从流的末尾创建，超过 block 无消息返回空
+	Read(group string, consumer string, rooms []string, count int64, block time.Duration) (map[string][]StreamEntry, error)
+	// Pending 读取 consumer 已读取但未确认的消息，组内其他消费者闲置超过 minIdle 的消息先认领过来
+	Pending(group string, consumer string, rooms []string, minIdle time.Duration, count int64) (map[string][]StreamEntry, error)
+	// Ack 确认消息已处理
+	Ack(group string, room string, streamIDs ...string) error
+}
+
+// EventStore 集群共享的即时事件(如正在输入)，发布后各节点立即收到
+// 不持久化，没有订阅者或订阅者来不及处理时直接丢弃
+type EventStore interface {
+	// PublishEvent 向所有节点发布事件，包括本节点
+	PublishEvent(event Event) error
+	// SubscribeEvents 订阅所有节点发布的事件，订阅断开时通道关闭
+	SubscribeEvents() (<-chan Event, error)
+}
+
+// RankStore 活跃度排行
+type RankStore interface {
+	// AddActivity 给用户追加活跃度
+	AddActivity(username string, number float64) error
+	// ActivityRank 按活跃度从高到低排列
+	ActivityRank() ([]RankItem, error)
+}
+
+// PresenceStore 集群共享的在线状态，每个在线用户记录其所在节点，过期未续期视为下线
+type PresenceStore interface {
+	// Claim 标记用户在 node 上线，已在任意节点在线时返回 false
+	Claim(username string, node string, ttl time.Duration) (bool, error)
+	// Refresh 续期，记录已过期时重新标记
+	Refresh(username string, node string, ttl time.Duration) error
+	// Release 标记下线，只删除属于 node 的记录
+	Release(username string, node string) error
+	// IsOnline 用户是否在任意节点在线
+	IsOnline(username string) (bool, error)
+	// Online 所有节点的在线用户
+	Online() ([]string, error)
+}
+
+// RoomStore 集群共享的房间列表，默认房间不在其中
+type RoomStore interface {
+	// AddRoom 创建房间，房间已存在返回 false
+	AddRoom(name string, owner string) (bool, error)
+	// Rooms 所有房间，房间名->创建者
+	Rooms() (map[string]string, error)
+	// SaveMemberRooms 记下用户断线时所在的房间，有效期和会话一致，rooms 为空时清除
+	SaveMemberRooms(username string, rooms []string, ttl time.Duration) error
+	// MemberRooms 用户断线时所在的房间，没有记录或已过期返回空
+	MemberRooms(username string) ([]string, error)
+}
+
+// SessionStore 登录会话，断线后凭令牌免密恢复，集群共享
+type SessionStore interface {
+	// SaveSession 保存会话并重置有效期
+	SaveSession(session Session, ttl time.Duration) error
+	// GetSession 按令牌查询会话，不存在或已过期返回 ErrSessionNotFound
+	GetSession(token string) (*Session, error)
+	// DeleteSession 注销会话
+	DeleteSession(token string) error
+}
+
+// FileStore 上传文件的元数据，文件内容存在服务端本地磁盘
+type FileStore interface {
+	// AddFile 登记一次上传，返回文件ID
+	AddFile(file File) (int64, error)
+	// GetFile 按ID查询，不存在返回 ErrFileNotFound
+	GetFile(id int64) (*File, error)
+	// FindUpload 查找同一用户发给同一房间或用户的同一文件尚未完成的上传，用于断点续传，没有返回 nil
+	FindUpload(owner string, room string, receiver string, checksum string) (*File, error)
+	// CompleteFile 标记上传完成
+	CompleteFile(id int64, completedAt time.Time) error
+	// DeleteFile 删除登记
+	DeleteFile(id int64) error
+	// UsedSpace 用户已占用的空间，未完成的上传按声明的大小计算
+	UsedSpace(owner string) (int64, error)
+	// StaleUploads 用户在 before 之前开始且仍未完成的上传
+	StaleUploads(owner string, before time.Time) ([]File, error)
+}
+
+// ReactionStore 消息的表情回应，每个用户对同一条消息的同一个表情只能回应一次
+type ReactionStore interface {
+	// AddReaction 回应表情，已经回应过返回 false
+	AddReaction(messageID int64, emoji string, username string) (bool, error)
+	// RemoveReaction 取消回应，没有回应过返回 false
+	RemoveReaction(messageID int64, emoji string, username string) (bool, error)
+	// Reactions 多条消息按表情汇总的回应，没有回应的消息不在结果中
+	Reactions(messageIDs []int64) (map[int64][]Reaction, error)
+}
+
+// Stores 聊天室依赖的全部存储
+type Stores struct {
+	Users     UserStore
+	Messages  MessageStore
+	Sanctions SanctionStore
+	Streams   StreamStore
+	Rank      RankStore
+	Presence  PresenceStore
+	Rooms     RoomStore
+	Sessions  SessionStore
+	Files     FileStore
+	Reactions ReactionStore
+	Events    EventStore
+}
+
+// NewStores 使用 MySQL 和 Redis，需先调用 ConnectDb 和 InitRedis，多个节点可共享
+func NewStores(streamMaxLen int64) *Stores {
+	mysqlStore := NewMySQLStore(DB)
+	redisStore := NewRedisStore(RDB, streamMaxLen)
+	return &Stores{
+		Users:     mysqlStore,
+		Messages:  mysqlStore,
+		Sanctions: mysqlStore,
+		Streams:   redisStore,
+		Rank:      redisStore,
+		Presence:  redisStore,
+		Rooms:     redisStore,
+		Sessions:  redisStore,
+		Files:     mysqlStore,
+		Reactions: redisStore,
+		Events:    redisStore,
+	}
+}
+
+// NewMemoryStores 全部使用内存实现，不依赖 MySQL 和 Redis，重启后数据丢失
+func NewMemoryStores(streamMaxLen int64) *Stores {
+	memoryStore := NewMemoryStore(streamMaxLen)
+	return &Stores{
+		Users:     memoryStore,
+		Messages:  memoryStore,
+		Sanctions: memoryStore,
+		Streams:   memoryStore,
+		Rank:      memoryStore,
+		Presence:  memoryStore,
+		Rooms:     memoryStore,
+		Sessions:  memoryStore,
+		Files:     memoryStore,
+		Reactions: memoryStore,
+		Events:    memoryStore,
+	}
+}
+
+// 处罚类型
+const (
+	SanctionMute = "mute" // 禁言，不能发送群聊和私聊
+	SanctionBan  = "ban"  // 封禁，不能登录
+)
+
+// SearchQuery 搜索条件，为空的条件不限制
+// 群聊所有人都能搜到，私聊只有收发双方能搜到，撤回和删除的消息搜不到
+type SearchQuery struct {
+	Username string    // 搜索的用户
+	Rooms    []string  // 搜索者所在的房间，群聊只搜这些房间
+	Keywords []string  // 内容须包含全部关键词，不区分大小写
+	Sender   string    // 发送者
+	Room     string    // 只搜该房间的群聊
+	With     string    // 只搜和该用户之间的私聊
+	Since    time.Time // 不早于该时间
+	Until    time.Time // 早于该时间
+	Offset   int64
+	Limit    int64
+}
+
+// Sanction 一条禁言或封禁记录
+type Sanction struct {
+	Username  string     `db:"username"`
+	Kind      string     `db:"kind"`
+	Moderator string     `db:"moderator"`
+	Reason    string     `db:"reason"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt *time.Time `db:"expires_at"` // 为空表示永久
+}
+
+// Session 登录会话
+type Session struct {
+	Token    string
+	Username string
+	Rooms    []string  // 断线时所在的房间，恢复后重新加入
+	LoginAt  time.Time // 首次登录时间，只补发之后的消息
+}
+
+// StreamEntry 流中的一条消息，系统广播的 ID 为 0
+type StreamEntry struct {
+	StreamID string // 流生成的ID，读取时才有
+	ID       int64  // 归档中的消息ID
+	Action   string // 不为空时是发给 Receiver 的控制指令(如踢下线)或对消息 ID 的编辑、撤回，不是聊天消息
+	Sender   string
+	Receiver string
+	Content  string
+	ParentID int64     // 回复的消息ID
+	Quote    string    // 回复的消息的摘要，发布时生成一次，各节点投递时不用再查询
+	Time     time.Time // 服务端接收时间
+}
+
+// eventBuffer 订阅即时事件的通道容量，满了之后的事件丢弃
+const eventBuffer = 100
+
+// Event 发布给所有节点的即时事件
+type Event struct {
+	Action   string // 事件类型
+	Sender   string
+	Receiver string // 不为空时只发给该用户
+	Room     string
+	Content  string
+}
+
+// ArchivedMessage 归档的聊天记录
+type ArchivedMessage struct {
+	Id          int64      `db:"id"`
+	StreamId    string     `db:"stream_id"`
+	Room        string     `db:"room"`
+	Sender      string     `db:"sender"`
+	Receiver    string     `db:"receiver"`
+	Content     string     `db:"content"`
+	CreatedAt   time.Time  `db:"created_at"`
+	ParentId    int64      `db:"parent_id"`    // 回复的消息ID，0 表示不是回复
+	ThreadId    int64      `db:"thread_id"`    // 所在话题第一条消息的ID，0 表示不是回复
+	EditedAt    *time.Time `db:"edited_at"`    // 为空表示没有编辑过
+	RecalledBy  string     `db:"recalled_by"`  // 撤回或删除消息的用户，为空表示没有撤回
+	DeliveredAt *time.Time `db:"delivered_at"` // 私聊送达接收者的时间，为空表示还没送达
+	ReadAt      *time.Time `db:"read_at"`      // 私聊被接收者读到的时间，为空表示未读或对方关闭了已读回执
+}
+
+// OfflineMessage 等待投递的离线私聊
+type OfflineMessage struct {
+	Id        int64     `db:"id"`
+	MessageId int64     `db:"message_id"` // 归档中的消息ID
+	Sender    string    `db:"sender"`
+	Receiver  string    `db:"receiver"`
+	Content   string    `db:"content"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// File 上传的文件，Room 和 Receiver 只有一个不为空
+type File struct {
+	Id          int64      `db:"id"`
+	Owner       string     `db:"owner"`
+	Room        string     `db:"room"`
+	Receiver    string     `db:"receiver"`
+	Name        string     `db:"name"`
+	Size        int64      `db:"size"`
+	Checksum    string     `db:"checksum"` // sha256，十六进制
+	CreatedAt   time.Time  `db:"created_at"`
+	CompletedAt *time.Time `db:"completed_at"` // 为空表示还在上传
+}
+
+// Reaction 一条消息上的一种表情回应
+type Reaction struct {
+	Emoji string
+	Users []string // 按回应的先后排列
+}
+
+// RankItem 活跃度排行中的一项
+type RankItem struct {
+	Username string
+	Score    float64
+}
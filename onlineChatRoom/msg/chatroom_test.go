@@ -0,0 +1,328 @@
+package msg
+
+import (
+	"errors"
+	"io"
+	"net"
+	"onlineChatRoom/config"
+	"onlineChatRoom/db"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeConn 记录服务端写出的消息，不读取任何内容
+type fakeConn struct {
+	sent chan *Message
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{sent: make(chan *Message, 100)}
+}
+
+func (c *fakeConn) ReadMessage() (*Message, error) {
+	return nil, io.EOF
+}
+
+func (c *fakeConn) WriteMessage(message *Message) error {
+	c.sent <- message
+	return nil
+}
+
+func (c *fakeConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (c *fakeConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000}
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+// next 等待下一条满足 match 的消息，之前的消息丢弃
+func (c *fakeConn) next(t *testing.T, match func(*Message) bool) *Message {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case message := <-c.sent:
+			if match(message) {
+				return message
+			}
+		case <-timeout:
+			t.Fatal("等待消息超时")
+			return nil
+		}
+	}
+}
+
+// ofType 匹配指定类型的消息
+func ofType(messageType MessageType) func(*Message) bool {
+	return func(message *Message) bool { return message.Type == messageType }
+}
+
+// fromSystem 匹配给请求方的系统提示
+func fromSystem(message *Message) bool {
+	return message.Type == MessageChat && message.Sender == "[系统]"
+}
+
+// newTestRoom 使用内存存储的聊天室
+func newTestRoom(t *testing.T) *ChatRoom {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Server.Storage = config.StorageMemory
+	cfg.Server.Files.Dir = t.TempDir()
+	cfg.Cluster.NodeID = "test"
+	cr := NewChatRoom(cfg, db.NewMemoryStores(cfg.Redis.StreamMaxLen))
+	// 默认房间的消费组要在发消息之前建好，否则从流的末尾开始读
+	cr.createGroup(DefaultRoom)
+	return cr
+}
+
+// register 注册用户
+func register(t *testing.T, cr *ChatRoom, username string) {
+	t.Helper()
+	conn := newFakeConn()
+	cr.Register(&Message{Type: MessageRegister, Sender: username, Content: "pw", Conn: conn})
+	if reply := conn.next(t, ofType(MessageRegister)); reply.Content != "OK" {
+		t.Fatalf("注册 %s 失败: %s", username, reply.Content)
+	}
+}
+
+// login 注册并登录，返回客户端和它的连接
+func login(t *testing.T, cr *ChatRoom, username string) (*Client, *fakeConn) {
+	t.Helper()
+	register(t, cr, username)
+	return join(t, cr, username)
+}
+
+// join 已注册的用户登录
+func join(t *testing.T, cr *ChatRoom, username string) (*Client, *fakeConn) {
+	t.Helper()
+	conn := newFakeConn()
+	client := cr.Join(&Message{Type: MessageJoin, Sender: username, Content: "pw", Conn: conn})
+	if client == nil {
+		t.Fatalf("%s 登录失败", username)
+	}
+	conn.next(t, ofType(MessageRegister))
+	return client, conn
+}
+
+// pendingCount 本节点消费组在默认房间中已读取未确认的消息数
+func pendingCount(t *testing.T, cr *ChatRoom) int {
+	t.Helper()
+	group, consumer := cr.streamGroup()
+	pending, err := cr.streams.Pending(group, consumer, []string{DefaultRoom}, reclaimIdle, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(pending[DefaultRoom])
+}
+
+func TestRegister(t *testing.T) {
+	cr := newTestRoom(t)
+	register(t, cr, "alice")
+
+	conn := newFakeConn()
+	cr.Register(&Message{Type: MessageRegister, Sender: "alice", Content: "pw", Conn: conn})
+	if reply := conn.next(t, ofType(MessageRegister)); !strings.Contains(reply.Content, "已被注册") {
+		t.Fatalf("重复注册的回复 = %q", reply.Content)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	cr := newTestRoom(t)
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     string
+	}{
+		{"用户不存在", "nobody", "pw", "不存在"},
+		{"密码错误", "alice", "wrong", "密码错误"},
+	}
+	register(t, cr, "alice")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newFakeConn()
+			if client := cr.Join(&Message{Type: MessageJoin, Sender: tt.username, Content: tt.password, Conn: conn}); client != nil {
+				t.Fatal("登录应该失败")
+			}
+			if reply := conn.next(t, ofType(MessageChat)); !strings.Contains(reply.Content, tt.want) {
+				t.Fatalf("回复 = %q，应包含 %q", reply.Content, tt.want)
+			}
+		})
+	}
+
+	conn := newFakeConn()
+	client := cr.Join(&Message{Type: MessageJoin, Sender: "alice", Content: "pw", Conn: conn})
+	if client == nil {
+		t.Fatal("登录失败")
+	}
+	if reply := conn.next(t, ofType(MessageRegister)); reply.Content != "OK" || reply.Token == "" {
+		t.Fatalf("登录回复 = %+v，应带上会话令牌", reply)
+	}
+	conn.next(t, ofType(MessageHistory))
+	if !cr.isMember(DefaultRoom, "alice") {
+		t.Fatal("登录后应自动加入默认房间")
+	}
+	if online, _ := cr.presence.IsOnline("alice"); !online {
+		t.Fatal("登录后应标记在线")
+	}
+
+	again := newFakeConn()
+	if cr.Join(&Message{Type: MessageJoin, Sender: "alice", Content: "pw", Conn: again}) != nil {
+		t.Fatal("同一账号不能重复登录")
+	}
+	if reply := again.next(t, ofType(MessageChat)); !strings.Contains(reply.Content, "已登录") {
+		t.Fatalf("重复登录的回复 = %q", reply.Content)
+	}
+}
+
+func TestPublishAck(t *testing.T) {
+	cr := newTestRoom(t)
+	alice, aliceConn := login(t, cr, "alice")
+
+	message := &Message{Type: MessageChat, Seq: 7, Sender: "alice", Room: DefaultRoom, Content: "hi", Conn: alice}
+	id, sentAt, err := cr.Publish(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ack(message, id, sentAt)
+	ack := aliceConn.next(t, ofType(MessageAck))
+	if ack.ID != id || ack.Seq != 7 || ack.Time != sentAt.UnixMilli() {
+		t.Fatalf("ack = %+v，应带回消息ID %d 和序号 7", ack, id)
+	}
+
+	_, _, err = cr.Publish(&Message{Type: MessageChat, Sender: "alice", Room: "nowhere", Content: "hi", Conn: alice})
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) || sendErr.Code != CodeNotInRoom {
+		t.Fatalf("发到没加入的房间 err = %v，应为 %s", err, CodeNotInRoom)
+	}
+	Nack(message, err)
+	if nack := aliceConn.next(t, ofType(MessageNack)); nack.Code != CodeNotInRoom || nack.Seq != 7 {
+		t.Fatalf("nack = %+v", nack)
+	}
+}
+
+func TestPrivateChat(t *testing.T) {
+	cr := newTestRoom(t)
+	alice, _ := login(t, cr, "alice")
+	_, bobConn := login(t, cr, "bob")
+
+	id, _, err := cr.Publish(&Message{Type: MessagePrivate, Sender: "alice", Receiver: "bob", Content: "hi bob", Conn: alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 私聊写入默认房间的流，由接收者所在的节点读取后投递
+	group, consumer := cr.streamGroup()
+	streams, err := cr.streams.Read(group, consumer, []string{DefaultRoom}, 100, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := pendingCount(t, cr); n != len(streams[DefaultRoom]) {
+		t.Fatalf("读取后未确认的消息数 = %d，应为 %d", n, len(streams[DefaultRoom]))
+	}
+	cr.dispatchStreams(group, streams)
+	if n := pendingCount(t, cr); n != 0 {
+		t.Fatalf("分发后仍有 %d 条未确认", n)
+	}
+
+	got := bobConn.next(t, ofType(MessagePrivate))
+	if got.ID != id || got.Sender != "alice" || got.Content != "hi bob" {
+		t.Fatalf("bob 收到 %+v", got)
+	}
+	m, err := cr.messages.GetMessage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.DeliveredAt == nil {
+		t.Fatal("投递后应记下送达时间")
+	}
+}
+
+func TestPrivateChatOffline(t *testing.T) {
+	cr := newTestRoom(t)
+	alice, aliceConn := login(t, cr, "alice")
+	register(t, cr, "bob")
+
+	id, _, err := cr.Publish(&Message{Type: MessagePrivate, Sender: "alice", Receiver: "bob", Content: "hi bob", Conn: alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply := aliceConn.next(t, fromSystem); !strings.Contains(reply.Content, "不在线") {
+		t.Fatalf("发送者收到 %q，应提示对方不在线", reply.Content)
+	}
+	// 离线私聊直接暂存，不写入流
+	group, consumer := cr.streamGroup()
+	streams, err := cr.streams.Read(group, consumer, []string{DefaultRoom}, 100, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range streams[DefaultRoom] {
+		if entry.ID == id {
+			t.Fatal("离线私聊不应写入流")
+		}
+	}
+
+	_, _, err = cr.Publish(&Message{Type: MessagePrivate, Sender: "alice", Receiver: "nobody", Content: "hi", Conn: alice})
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) || sendErr.Code != CodeNoUser {
+		t.Fatalf("私聊不存在的用户 err = %v，应为 %s", err, CodeNoUser)
+	}
+
+	_, bobConn := join(t, cr, "bob")
+	got := bobConn.next(t, ofType(MessagePrivate))
+	if got.ID != id || got.Sender != "alice" || !strings.HasSuffix(got.Content, "hi bob") {
+		t.Fatalf("bob 登录后收到 %+v", got)
+	}
+	offline, err := cr.messages.ListOffline("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offline) != 0 {
+		t.Fatalf("投递后仍暂存 %d 条离线私聊", len(offline))
+	}
+}
+
+func TestHandleStreams(t *testing.T) {
+	cr := newTestRoom(t)
+	alice, _ := login(t, cr, "alice")
+	_, bobConn := login(t, cr, "bob")
+
+	// 模拟上次读取之后没来得及确认就重启了
+	id, _, err := cr.Publish(&Message{Type: MessageChat, Sender: "alice", Room: DefaultRoom, Content: "before", Conn: alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, consumer := cr.streamGroup()
+	if _, err = cr.streams.Read(group, consumer, []string{DefaultRoom}, 100, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	go cr.HandleStreams()
+	got := bobConn.next(t, func(m *Message) bool { return m.Type == MessageChat && m.ID == id })
+	if got.Content != "[lobby] alice: before" {
+		t.Fatalf("补处理的消息 = %q", got.Content)
+	}
+
+	id, _, err = cr.Publish(&Message{Type: MessageChat, Sender: "alice", Room: DefaultRoom, Content: "after", Conn: alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobConn.next(t, func(m *Message) bool { return m.Type == MessageChat && m.ID == id })
+
+	deadline := time.Now().Add(time.Second)
+	for pendingCount(t, cr) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("处理完的消息没有确认")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,125 @@
+package msg
+
+import (
+	"errors"
+	"log"
+	"net"
+	"onlineChatRoom/db"
+	"onlineChatRoom/utils"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClientClosed 客户端连接已关闭
+var ErrClientClosed = errors.New("client closed")
+
+// ErrSendQueueFull 客户端发送队列已满
+var ErrSendQueueFull = errors.New("send queue full")
+
+// Client 客户端，登录后发往该客户端的消息都先进入有界队列，由独立的写协程发送
+// 队列满或写超时说明对端太慢或已失联，直接断开，不让它拖住整个聊天室
+type Client struct {
+	Username      string
+	Conn          Conn
+	LastHeartbeat time.Time
+
+	send      chan *Message
+	done      chan struct{}
+	closeOnce sync.Once
+
+	session      db.Session  // 登录会话，Token 为空表示没有会话
+	sessionEnded atomic.Bool // 主动退出或被踢下线，断开后不再保留会话
+}
+
+// NewClient 创建客户端并启动写协程
+func NewClient(username string, conn Conn, queueSize int, writeTimeout time.Duration) *Client {
+	c := &Client{
+		Username:      username,
+		Conn:          conn,
+		LastHeartbeat: time.Now(),
+		send:          make(chan *Message, queueSize),
+		done:          make(chan struct{}),
+	}
+	go c.writeLoop(writeTimeout)
+	return c
+}
+
+// WriteMessage 消息入队，不阻塞；队列满时断开该客户端
+func (c *Client) WriteMessage(message *Message) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+	select {
+	case c.send <- message:
+		return nil
+	default:
+		log.Printf("用户 %s 发送队列已满，断开连接\n", c.Username)
+		_ = c.Close()
+		return ErrSendQueueFull
+	}
+}
+
+// writeLoop 依次把队列中的消息写入连接，退出时关闭连接
+func (c *Client) writeLoop(writeTimeout time.Duration) {
+	defer utils.CloseConn(c.Conn, c.Username)
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			// CloseAfter 放入的结束标记，之前的消息都已发出
+			if message == nil {
+				_ = c.Close()
+				return
+			}
+			if err := c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+				log.Printf("用户 %s SetWriteDeadline: %v\n", c.Username, err)
+			}
+			if err := c.Conn.WriteMessage(message); err != nil {
+				log.Printf("向用户 %s 写消息失败，断开连接: %v\n", c.Username, err)
+				_ = c.Close()
+				return
+			}
+		}
+	}
+}
+
+// Close 通知写协程退出并关闭连接，不阻塞，可重复调用；读协程随之出错退出并调用 Leave
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
+
+// CloseAfter 发完 message 及之前已入队的消息后再断开，用于踢人时先送达提示
+func (c *Client) CloseAfter(message *Message) {
+	if err := c.WriteMessage(message); err != nil {
+		return
+	}
+	select {
+	case c.send <- nil:
+	default:
+		_ = c.Close()
+	}
+}
+
+// ReadMessage 以下方法让 Client 可以直接作为 Message.Conn 使用
+func (c *Client) ReadMessage() (*Message, error) {
+	return c.Conn.ReadMessage()
+}
+
+func (c *Client) SetReadDeadline(t time.Time) error {
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *Client) SetWriteDeadline(t time.Time) error {
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *Client) RemoteAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
@@ -0,0 +1,96 @@
+package msg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"onlineChatRoom/utils"
+	"sync"
+	"time"
+)
+
+// Conn 服务端视角的客户端连接，屏蔽 TCP 长度前缀帧和 WebSocket 帧的差异
+type Conn interface {
+	ReadMessage() (*Message, error)
+	WriteMessage(message *Message) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// tcpConn 使用 utils.SendMessage 长度前缀帧的 TCP(或 TLS) 连接
+type tcpConn struct {
+	net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex // 保证一帧完整写入，不与其他 goroutine 交错
+}
+
+// NewTCPConn 包装 TCP 连接
+func NewTCPConn(conn net.Conn) Conn {
+	return &tcpConn{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *tcpConn) ReadMessage() (*Message, error) {
+	return ReadJsonMessage(c.reader)
+}
+
+func (c *tcpConn) WriteMessage(message *Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return SendJsonMessage(c.Conn, message)
+}
+
+// wsConn WebSocket 连接，每个文本帧是一条 JSON 消息
+type wsConn struct {
+	ws *websocket.Conn
+	mu sync.Mutex // gorilla/websocket 不支持并发写
+}
+
+// NewWSConn 包装 WebSocket 连接
+func NewWSConn(ws *websocket.Conn) Conn {
+	ws.SetReadLimit(utils.MaxMessageLength)
+	return &wsConn{ws: ws}
+}
+
+func (c *wsConn) ReadMessage() (*Message, error) {
+	_, data, err := c.ws.ReadMessage()
+	if err != nil {
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("ReadJsonMessage failed:%w", err)
+	}
+	return UnJsonMessage(data)
+}
+
+func (c *wsConn) WriteMessage(message *Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("SendJsonMessage failed:%w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *wsConn) Close() error {
+	return c.ws.Close()
+}
@@ -0,0 +1,191 @@
+package msg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+	"time"
+)
+
+// streams流中对已发送消息的修改，ID 是被修改的消息
+const (
+	actionEdit   = "edit"   // 发送者编辑
+	actionRecall = "recall" // 发送者撤回
+	actionDelete = "delete" // 管理员删除
+)
+
+// updateTypes 修改推送给客户端时的消息类型
+var updateTypes = map[string]MessageType{
+	actionEdit:   MessageEdit,
+	actionRecall: MessageRecall,
+	actionDelete: MessageDelete,
+}
+
+// EditMessage 发送者在 editWindow 内修改自己的群聊或私聊，msg.ID 是要修改的消息
+func (cr *ChatRoom) EditMessage(msg *Message) {
+	if msg.Content == "" {
+		cr.replySystem(msg, "内容不能为空")
+		return
+	}
+	m, ok := cr.ownMessage(msg, "编辑")
+	if !ok {
+		return
+	}
+	if err := cr.checkMuted(msg.Sender); err != nil {
+		var sendErr *SendError
+		if !errors.As(err, &sendErr) {
+			log.Println("查询禁言状态失败:", err)
+			cr.replySystem(msg, "操作失败，请稍后重试")
+			return
+		}
+		cr.replySystem(msg, sendErr.Reason)
+		return
+	}
+	if err := cr.messages.EditMessage(m.Id, msg.Content, time.Now()); err != nil {
+		log.Println("编辑消息失败:", err)
+		cr.replySystem(msg, "操作失败，请稍后重试")
+		return
+	}
+	cr.sendUpdate(actionEdit, m, msg.Content)
+	cr.replySystem(msg, fmt.Sprintf("已编辑消息 #%d", m.Id))
+	fmt.Printf("%s 编辑了消息 #%d: %s\n", msg.Sender, m.Id, msg.Content)
+}
+
+// RecallMessage 发送者在 editWindow 内撤回自己的群聊或私聊
+func (cr *ChatRoom) RecallMessage(msg *Message) {
+	m, ok := cr.ownMessage(msg, "撤回")
+	if !ok {
+		return
+	}
+	cr.recall(msg, m, actionRecall)
+}
+
+// DeleteMessage 管理员删除任何人的群聊或私聊，不受时间限制
+func (cr *ChatRoom) DeleteMessage(msg *Message) {
+	if !cr.isModerator(msg.Sender) {
+		cr.replySystem(msg, "只有管理员可以执行该操作")
+		return
+	}
+	m, ok := cr.findMessage(msg)
+	if !ok {
+		return
+	}
+	cr.recall(msg, m, actionDelete)
+}
+
+// recall 清空消息内容并推送给接收者
+func (cr *ChatRoom) recall(msg *Message, m *db.ArchivedMessage, action string) {
+	if err := cr.messages.RecallMessage(m.Id, msg.Sender); err != nil {
+		log.Println("撤回消息失败:", err)
+		cr.replySystem(msg, "操作失败，请稍后重试")
+		return
+	}
+	m.RecalledBy = msg.Sender
+	cr.sendUpdate(action, m, displayContent(*m))
+	verb := "撤回"
+	if action == actionDelete {
+		verb = "删除"
+	}
+	cr.replySystem(msg, fmt.Sprintf("已%s消息 #%d", verb, m.Id))
+	fmt.Printf("%s %s了 %s 的消息 #%d\n", msg.Sender, verb, m.Sender, m.Id)
+}
+
+// findMessage 查询要修改的消息，不存在或已撤回时直接回复原因
+func (cr *ChatRoom) findMessage(msg *Message) (*db.ArchivedMessage, bool) {
+	m, err := cr.messages.GetMessage(msg.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrMessageNotFound) {
+			cr.replySystem(msg, fmt.Sprintf("消息 #%d 不存在", msg.ID))
+		} else {
+			log.Printf("查询消息 #%d 失败: %v", msg.ID, err)
+			cr.replySystem(msg, "操作失败，请稍后重试")
+		}
+		return nil, false
+	}
+	if m.RecalledBy != "" {
+		cr.replySystem(msg, fmt.Sprintf("消息 #%d 已被撤回", msg.ID))
+		return nil, false
+	}
+	return m, true
+}
+
+// ownMessage 查询发送者自己在 editWindow 内发送的消息，不满足时直接回复原因
+func (cr *ChatRoom) ownMessage(msg *Message, verb string) (*db.ArchivedMessage, bool) {
+	m, ok := cr.findMessage(msg)
+	if !ok {
+		return nil, false
+	}
+	if m.Sender != msg.Sender {
+		cr.replySystem(msg, fmt.Sprintf("只能%s自己发送的消息", verb))
+		return nil, false
+	}
+	if window := cr.cfg.Server.EditWindow; time.Since(m.CreatedAt) > window {
+		cr.replySystem(msg, fmt.Sprintf("消息发送超过 %s，不能再%s", window, verb))
+		return nil, false
+	}
+	return m, true
+}
+
+// sendUpdate 通过消息所在的streams流把修改推送到各节点，私聊走默认房间的流
+func (cr *ChatRoom) sendUpdate(action string, m *db.ArchivedMessage, content string) {
+	roomName := m.Room
+	if m.Receiver != "" {
+		roomName = DefaultRoom
+	}
+	_, err := cr.streams.Append(roomName, db.StreamEntry{
+		Action:   action,
+		ID:       m.Id,
+		Sender:   m.Sender,
+		Receiver: m.Receiver,
+		Content:  content,
+		Time:     time.Now(),
+	})
+	if err != nil {
+		log.Println("消息修改写入 streams 流失败:", err)
+	}
+}
+
+// dispatchUpdate 把消息的修改推送给本节点在线的接收者，编辑和撤回的发送者本人已收到回复
+func (cr *ChatRoom) dispatchUpdate(roomName string, entry db.StreamEntry) {
+	message := &Message{
+		Type:    updateTypes[entry.Action],
+		ID:      entry.ID,
+		Sender:  entry.Sender,
+		Room:    roomName,
+		Content: entry.Content,
+	}
+	exclude := entry.Sender
+	if entry.Action == actionDelete {
+		exclude = ""
+	}
+	if entry.Receiver == "" {
+		cr.broadcast(roomName, exclude, message)
+		return
+	}
+	message.Room = ""
+	cr.Mutex.Lock()
+	defer cr.Mutex.Unlock()
+	for _, username := range []string{entry.Receiver, entry.Sender} {
+		client, ok := cr.Clients[username]
+		if username == exclude || !ok {
+			continue
+		}
+		if err := client.WriteMessage(message); err != nil {
+			log.Println("dispatchUpdate:", err)
+		}
+	}
+}
+
+// displayContent 历史中展示的内容，撤回的显示占位，编辑过的加上标记
+func displayContent(m db.ArchivedMessage) string {
+	switch {
+	case m.RecalledBy == m.Sender:
+		return "[消息已撤回]"
+	case m.RecalledBy != "":
+		return "[消息已被管理员删除]"
+	case m.EditedAt != nil:
+		return m.Content + " (已编辑)"
+	}
+	return m.Content
+}
@@ -0,0 +1,312 @@
+package msg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"onlineChatRoom/db"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// 文件传输的 nack 错误码
+const (
+	CodeNoFile   = "no_such_file"      // 文件不存在或无权访问
+	CodeBadFile  = "bad_file"          // 文件名、大小或分块不合法
+	CodeQuota    = "quota_exceeded"    // 超出单个文件大小或用户空间上限
+	CodeChecksum = "checksum_mismatch" // 收齐后校验和不一致，需重新上传
+)
+
+// maxFileNameLength 文件名最大长度，字节
+const maxFileNameLength = 255
+
+// HandleFile 处理文件上传和下载，在该连接自己的读协程中处理，读写磁盘不占用聊天室的协程
+// 每次只处理一块，客户端收到回复后再发下一块，慢客户端不会撑满发送队列
+func (cr *ChatRoom) HandleFile(msg *Message) {
+	var err error
+	switch msg.Type {
+	case MessageFileUpload:
+		err = cr.startUpload(msg)
+	case MessageFileChunk:
+		err = cr.receiveChunk(msg)
+	case MessageFileDownload:
+		err = cr.sendChunk(msg)
+	}
+	if err != nil {
+		Nack(msg, err)
+	}
+}
+
+// filePath 文件在磁盘上的路径，上传完成前带 .part 后缀
+func (cr *ChatRoom) filePath(id int64, complete bool) string {
+	name := strconv.FormatInt(id, 10)
+	if !complete {
+		name += ".part"
+	}
+	return filepath.Join(cr.cfg.Server.Files.Dir, name)
+}
+
+// startUpload 开始上传，同一文件有未完成的上传时从已收到的位置续传
+func (cr *ChatRoom) startUpload(msg *Message) error {
+	cfg := cr.cfg.Server.Files
+	name := filepath.Base(msg.Content)
+	if msg.Content == "" || name == "." || name == ".." || len(name) > maxFileNameLength {
+		return &SendError{Code: CodeBadFile, Reason: "文件名不合法"}
+	}
+	if len(msg.Checksum) != sha256.Size*2 || msg.Size <= 0 {
+		return &SendError{Code: CodeBadFile, Reason: "文件大小或校验和不合法"}
+	}
+	if msg.Size > cfg.MaxFileSize {
+		return &SendError{Code: CodeQuota, Reason: fmt.Sprintf("文件不能超过 %s", formatSize(cfg.MaxFileSize))}
+	}
+	if err := cr.checkMuted(msg.Sender); err != nil {
+		return err
+	}
+	room := ""
+	if msg.Receiver != "" {
+		if _, err := cr.users.SearchUser(msg.Receiver); err != nil {
+			if errors.Is(err, db.ErrUserNotFound) {
+				return &SendError{Code: CodeNoUser, Reason: fmt.Sprintf("用户 %s 不存在", msg.Receiver)}
+			}
+			return err
+		}
+	} else {
+		room = DefaultRoom
+		if msg.Room != "" {
+			room = msg.Room
+		}
+		if !cr.isMember(room, msg.Sender) {
+			return &SendError{Code: CodeNotInRoom, Reason: fmt.Sprintf("你不在房间 %s 中，请先 join %s", room, room)}
+		}
+	}
+	cr.removeStaleUploads(msg.Sender)
+
+	file, err := cr.files.FindUpload(msg.Sender, room, msg.Receiver, msg.Checksum)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		used, err := cr.files.UsedSpace(msg.Sender)
+		if err != nil {
+			return err
+		}
+		if used+msg.Size > cfg.Quota {
+			return &SendError{Code: CodeQuota, Reason: fmt.Sprintf("空间不足，已使用 %s，上限 %s", formatSize(used), formatSize(cfg.Quota))}
+		}
+		file = &db.File{
+			Owner:     msg.Sender,
+			Room:      room,
+			Receiver:  msg.Receiver,
+			Name:      name,
+			Size:      msg.Size,
+			Checksum:  msg.Checksum,
+			CreatedAt: time.Now(),
+		}
+		if file.Id, err = cr.files.AddFile(*file); err != nil {
+			return err
+		}
+	}
+	received, err := cr.partSize(file.Id)
+	if err != nil {
+		return err
+	}
+	return cr.replyUpload(msg, file.Id, received)
+}
+
+// replyUpload 告诉客户端已收到的字节数和每块的大小上限，客户端据此发送下一块
+func (cr *ChatRoom) replyUpload(msg *Message, id int64, received int64) error {
+	return msg.Conn.WriteMessage(&Message{
+		Type:   MessageFileUpload,
+		Seq:    msg.Seq,
+		FileID: id,
+		Offset: received,
+		Size:   int64(cr.cfg.Server.Files.ChunkSize),
+	})
+}
+
+// partSize 已收到的字节数，没有临时文件时创建
+func (cr *ChatRoom) partSize(id int64) (int64, error) {
+	if err := os.MkdirAll(cr.cfg.Server.Files.Dir, 0o755); err != nil {
+		return 0, fmt.Errorf("os.MkdirAll failed:%w", err)
+	}
+	f, err := os.OpenFile(cr.filePath(id, false), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("os.OpenFile failed:%w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat part file failed:%w", err)
+	}
+	return info.Size(), nil
+}
+
+// receiveChunk 追加一块，收齐后校验并把文件作为一条消息发到房间或私聊
+func (cr *ChatRoom) receiveChunk(msg *Message) error {
+	file, err := cr.files.GetFile(msg.FileID)
+	if errors.Is(err, db.ErrFileNotFound) || (err == nil && (file.Owner != msg.Sender || file.CompletedAt != nil)) {
+		return &SendError{Code: CodeNoFile, Reason: "上传不存在或已完成，请重新发送"}
+	}
+	if err != nil {
+		return err
+	}
+	received, err := cr.partSize(file.Id)
+	if err != nil {
+		return err
+	}
+	// 位置对不上(如重连后重发了已收到的块)时告诉客户端从哪里继续
+	if msg.Offset != received {
+		return cr.replyUpload(msg, file.Id, received)
+	}
+	if len(msg.Data) == 0 || len(msg.Data) > cr.cfg.Server.Files.ChunkSize || received+int64(len(msg.Data)) > file.Size {
+		return &SendError{Code: CodeBadFile, Reason: "文件分块不合法"}
+	}
+	f, err := os.OpenFile(cr.filePath(file.Id, false), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile failed:%w", err)
+	}
+	_, err = f.Write(msg.Data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write part file failed:%w", err)
+	}
+	received += int64(len(msg.Data))
+	if received < file.Size {
+		return cr.replyUpload(msg, file.Id, received)
+	}
+	return cr.completeUpload(msg, file)
+}
+
+// completeUpload 校验收齐的文件，通过后发一条带文件ID的消息，ack/nack 即为整个上传的结果
+func (cr *ChatRoom) completeUpload(msg *Message, file *db.File) error {
+	checksum, err := fileChecksum(cr.filePath(file.Id, false))
+	if err != nil {
+		return err
+	}
+	if checksum != file.Checksum {
+		cr.removeFile(*file)
+		return &SendError{Code: CodeChecksum, Reason: fmt.Sprintf("文件 %s 校验失败，请重新发送", file.Name)}
+	}
+	if err = os.Rename(cr.filePath(file.Id, false), cr.filePath(file.Id, true)); err != nil {
+		return fmt.Errorf("os.Rename failed:%w", err)
+	}
+	if err = cr.files.CompleteFile(file.Id, time.Now()); err != nil {
+		return err
+	}
+	fmt.Printf("%s 上传了文件 %s(%s)\n", file.Owner, file.Name, formatSize(file.Size))
+	notice := &Message{
+		Type:     MessageChat,
+		Seq:      msg.Seq,
+		Sender:   file.Owner,
+		Receiver: file.Receiver,
+		Room:     file.Room,
+		Content:  fmt.Sprintf("[文件] %s (%s)，输入 download %d 下载", file.Name, formatSize(file.Size), file.Id),
+		Conn:     msg.Conn,
+	}
+	if file.Receiver != "" {
+		notice.Type = MessagePrivate
+	}
+	id, sentAt, err := cr.Publish(notice)
+	if err != nil {
+		return err
+	}
+	Ack(notice, id, sentAt)
+	return nil
+}
+
+// sendChunk 发送从 msg.Offset 开始的一块，只有房间成员或私聊双方可以下载
+func (cr *ChatRoom) sendChunk(msg *Message) error {
+	file, err := cr.files.GetFile(msg.FileID)
+	if err != nil && !errors.Is(err, db.ErrFileNotFound) {
+		return err
+	}
+	if file == nil || file.CompletedAt == nil || !cr.canDownload(file, msg.Sender) {
+		return &SendError{Code: CodeNoFile, Reason: fmt.Sprintf("文件 %d 不存在或无权下载", msg.FileID)}
+	}
+	if msg.Offset < 0 || msg.Offset >= file.Size {
+		return &SendError{Code: CodeBadFile, Reason: "下载位置不合法"}
+	}
+	f, err := os.Open(cr.filePath(file.Id, true))
+	if err != nil {
+		return fmt.Errorf("os.Open failed:%w", err)
+	}
+	defer f.Close()
+	buf := make([]byte, min(int64(cr.cfg.Server.Files.ChunkSize), file.Size-msg.Offset))
+	if _, err = f.ReadAt(buf, msg.Offset); err != nil {
+		return fmt.Errorf("read file failed:%w", err)
+	}
+	return msg.Conn.WriteMessage(&Message{
+		Type:     MessageFileDownload,
+		Seq:      msg.Seq,
+		FileID:   file.Id,
+		Content:  file.Name,
+		Size:     file.Size,
+		Checksum: file.Checksum,
+		Offset:   msg.Offset,
+		Data:     buf,
+	})
+}
+
+// canDownload 房间里的文件房间成员可以下载，私聊的文件只有双方可以下载
+func (cr *ChatRoom) canDownload(file *db.File, username string) bool {
+	if file.Receiver != "" {
+		return username == file.Owner || username == file.Receiver
+	}
+	return cr.isMember(file.Room, username)
+}
+
+// removeStaleUploads 清除用户长时间未完成的上传，释放占用的空间
+func (cr *ChatRoom) removeStaleUploads(username string) {
+	files, err := cr.files.StaleUploads(username, time.Now().Add(-cr.cfg.Server.Files.UploadTTL))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, file := range files {
+		cr.removeFile(file)
+	}
+}
+
+// removeFile 删除未完成的上传及其临时文件
+func (cr *ChatRoom) removeFile(file db.File) {
+	if err := os.Remove(cr.filePath(file.Id, false)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println("删除临时文件失败:", err)
+	}
+	if err := cr.files.DeleteFile(file.Id); err != nil {
+		log.Println(err)
+	}
+}
+
+// fileChecksum 计算文件的 sha256
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("os.Open failed:%w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash file failed:%w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// formatSize 以合适的单位展示文件大小
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", size)
+}
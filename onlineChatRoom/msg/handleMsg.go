@@ -4,48 +4,141 @@ import (
 	"fmt"
 	"log"
 	"onlineChatRoom/db"
+	"time"
 )
 
-// HandleStreams 处理streams流消息
+// reclaimIdle 其他消费者的未确认消息闲置超过该时长才认领，每个消费组正常只有本节点一个消费者
+const reclaimIdle = time.Minute
+
+// HandleStreams 处理所有房间的streams流消息
+// 每个节点以自己的消费组读取全部房间的流，只投递给连接在本节点的用户
+// 处理完才确认，重启后先补处理上次没确认的消息，再从上次的位置继续读
 func (cr *ChatRoom) HandleStreams() {
-	lastID := "0-0"
+	group, consumer := cr.streamGroup()
+	cr.syncRooms()
 	for {
-		messages, err := db.ReadStreams(1, lastID)
+		pending, err := cr.streams.Pending(group, consumer, cr.roomNames(), reclaimIdle, 10)
 		if err != nil {
-			log.Println("读取 streams 出错:", err)
+			log.Println("读取未确认的 streams 消息出错:", err)
+			time.Sleep(time.Second)
 			continue
 		}
-		if len(messages) == 0 {
+		if len(pending) == 0 {
+			break
+		}
+		cr.dispatchStreams(group, pending)
+	}
+	for {
+		// 每轮重新获取房间列表，新建的房间最多等待一个 block 周期就会被读取
+		cr.syncRooms()
+		streams, err := cr.streams.Read(group, consumer, cr.roomNames(), 10, time.Second)
+		if err != nil {
+			log.Println("读取 streams 出错:", err)
+			time.Sleep(time.Second)
 			continue
 		}
-		for _, m := range messages {
-			sender := m.Values["sender"].(string)
-			receiver := m.Values["receiver"].(string)
-			content := m.Values["content"].(string)
-			// 系统广播分支
-			if sender == "系统广播" {
-				cr.broadcast(receiver, fmt.Sprintf("%s: %s", sender, content))
-				lastID = m.ID
-				continue
-			}
+		cr.dispatchStreams(group, streams)
+	}
+}
 
-			msg := &Message{
-				Sender:   sender,
-				Receiver: receiver,
-				Content:  content,
-				Type:     MessageChat,
-			}
-			// 如果 sender 在线，再附加 Conn
-			if client, ok := cr.Clients[m.Values["sender"].(string)]; ok {
-				msg.Conn = client.Conn
-			}
-			if msg.Receiver != "" {
-				cr.PrivateChat(msg)
-			} else {
-				cr.broadcast(msg.Sender, fmt.Sprintf("%s: %s", msg.Sender, msg.Content))
+// streamGroup 本节点读取streams流的消费组和消费者，都由节点ID决定，重启后不变
+func (cr *ChatRoom) streamGroup() (group string, consumer string) {
+	return "chatroom:" + cr.nodeID(), cr.nodeID()
+}
+
+// dispatchStreams 分发读到的streams流消息，分发完一个房间确认一次
+func (cr *ChatRoom) dispatchStreams(group string, streams map[string][]db.StreamEntry) {
+	for roomName, entries := range streams {
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			cr.dispatchStream(roomName, entry)
+			ids = append(ids, entry.StreamID)
+		}
+		if err := cr.streams.Ack(group, roomName, ids...); err != nil {
+			log.Println("确认 streams 消息出错:", err)
+		}
+	}
+}
+
+// dispatchStream 分发一条streams流消息
+func (cr *ChatRoom) dispatchStream(roomName string, entry db.StreamEntry) {
+	switch entry.Action {
+	case "":
+	case actionEdit, actionRecall, actionDelete:
+		cr.dispatchUpdate(roomName, entry)
+		return
+	case actionReact, actionUnreact:
+		cr.dispatchReaction(roomName, entry)
+		return
+	case actionRead:
+		cr.dispatchReceipt(entry)
+		return
+	default:
+		cr.dispatchAction(entry)
+		return
+	}
+	// 系统广播分支
+	if entry.Sender == "系统广播" {
+		cr.broadcast(roomName, entry.Receiver, &Message{
+			Type:    MessageChat,
+			Sender:  entry.Sender,
+			Room:    roomName,
+			Content: fmt.Sprintf("[%s] %s: %s", roomName, entry.Sender, entry.Content),
+		})
+		return
+	}
+
+	msg := &Message{
+		ID:       entry.ID,
+		Time:     entry.Time.UnixMilli(),
+		Sender:   entry.Sender,
+		Receiver: entry.Receiver,
+		Content:  entry.Content,
+		Room:     roomName,
+		Type:     MessageChat,
+		ParentID: entry.ParentID,
+		Quote:    entry.Quote,
+	}
+	// 如果 sender 在线，再附加 Conn
+	cr.Mutex.Lock()
+	if client, ok := cr.Clients[entry.Sender]; ok {
+		msg.Conn = client
+	}
+	cr.Mutex.Unlock()
+	if msg.Receiver != "" {
+		cr.PrivateChat(msg)
+	} else {
+		mentioned := mentionsOf(msg.Content, msg.Sender)
+		cr.broadcastChat(roomName, &Message{
+			Type:     MessageChat,
+			ID:       msg.ID,
+			Time:     msg.Time,
+			Sender:   msg.Sender,
+			Room:     roomName,
+			Content:  fmt.Sprintf("[%s] %s: %s", roomName, msg.Sender, msg.Content),
+			ParentID: msg.ParentID,
+			Quote:    msg.Quote,
+		}, mentioned)
+		if msg.Conn != nil {
+			cr.storeMentions(msg, mentioned)
+		}
+	}
+}
+
+// HandleEvents 处理各节点发布的即时事件，只投递给连接在本节点的用户，订阅断开后重新订阅
+func (cr *ChatRoom) HandleEvents() {
+	for {
+		events, err := cr.events.SubscribeEvents()
+		if err != nil {
+			log.Println("订阅即时事件出错:", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for event := range events {
+			switch event.Action {
+			case eventTyping:
+				cr.deliverTyping(event)
 			}
-			_ = db.AddActivity(msg.Sender, 1)
-			lastID = m.ID // 更新游标，防止重复读取
 		}
 	}
 }
@@ -61,13 +154,64 @@ func (cr *ChatRoom) HandleChanMessages() {
 		switch msg.Type {
 		case MessageHeart:
 			cr.PongHeart(msg.Sender)
+		case MessageTyping:
+			cr.Typing(msg)
 		case MessageList:
 			cr.ShowClients(msg.Sender, msg.Conn)
 		case MessageLeave:
-			cr.Leave(msg.Sender)
+			if client, ok := msg.Conn.(*Client); ok {
+				cr.Logout(client)
+			}
 		case MessageRank:
-			SendRank(msg.Sender, msg.Conn)
+			cr.SendRank(msg.Sender, msg.Conn)
+		case MessageCreateRoom:
+			cr.CreateRoom(msg)
+		case MessageJoinRoom:
+			cr.JoinRoom(msg)
+		case MessageLeaveRoom:
+			cr.LeaveRoom(msg)
+		case MessageListRooms:
+			cr.ShowRooms(msg)
+		case MessageKick:
+			cr.Kick(msg)
+		case MessageMute:
+			cr.Mute(msg)
+		case MessageBan:
+			cr.Ban(msg)
+		case MessageUnmute:
+			cr.Unmute(msg)
+		case MessageUnban:
+			cr.Unban(msg)
+		case MessageEdit:
+			cr.EditMessage(msg)
+		case MessageRecall:
+			cr.RecallMessage(msg)
+		case MessageDelete:
+			cr.DeleteMessage(msg)
+		case MessageReact:
+			cr.React(msg)
+		case MessageUnreact:
+			cr.Unreact(msg)
+		case MessageRead:
+			cr.MarkRead(msg)
 		default:
 		}
 	}
 }
+
+// HandleQuery 处理话题、已读状态、搜索和历史消息的查询，在该连接自己的读协程中处理
+// 这些查询可能要扫描大量归档，不能占用聊天室的协程拖慢所有人的心跳和命令
+// 加入和离开房间在 HandleChanMessages 中处理，和这里的查询没有先后保证：刚发出 join 就查询该房间可能还没加入
+// 加入房间时已经推送了最近的历史消息，客户端要在收到加入成功的回复之后再查询更早的
+func (cr *ChatRoom) HandleQuery(msg *Message) {
+	switch msg.Type {
+	case MessageThread:
+		cr.ShowThread(msg)
+	case MessageReceipts:
+		cr.Receipts(msg)
+	case MessageSearch:
+		cr.Search(msg)
+	case MessageHistory:
+		cr.ShowHistory(msg)
+	}
+}
@@ -0,0 +1,59 @@
+package msg
+
+import (
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+)
+
+// history 命令每页的默认条数和上限
+const (
+	defaultHistoryPage = 20
+	maxHistoryPage     = 100
+)
+
+// ShowHistory 按页查询历史消息：msg.Receiver 不为空时是和该用户的私聊，否则是 msg.Room 的群聊
+// msg.ID 不为 0 时只查比它更早的消息，msg.Limit 是条数，为 0 时使用默认条数
+func (cr *ChatRoom) ShowHistory(msg *Message) {
+	limit := msg.Limit
+	if limit == 0 {
+		limit = defaultHistoryPage
+	}
+	if limit < 0 || limit > maxHistoryPage || msg.ID < 0 {
+		cr.replySystem(msg, fmt.Sprintf("条数必须在 1 到 %d 之间", maxHistoryPage))
+		return
+	}
+	page := &Message{Type: MessageHistory, ID: msg.ID, Limit: limit}
+	var history []db.ArchivedMessage
+	var err error
+	if msg.Receiver != "" {
+		page.Receiver = msg.Receiver
+		history, err = cr.messages.Conversation(msg.Sender, msg.Receiver, msg.ID, limit)
+	} else {
+		// 房间的历史只有成员能看，和加入房间时推送的一样
+		if !cr.isMember(msg.Room, msg.Sender) {
+			cr.replySystem(msg, fmt.Sprintf("你不在房间 %s 中", msg.Room))
+			return
+		}
+		page.Room = msg.Room
+		history, err = cr.messages.History(msg.Room, msg.ID, limit)
+	}
+	if err != nil {
+		log.Printf("用户 %s 查询历史消息失败: %v", msg.Sender, err)
+		cr.replySystem(msg, "查询历史消息失败，请稍后重试")
+		return
+	}
+	if err = cr.sendPage(msg.Conn, page, history); err != nil {
+		log.Println("发送历史消息失败:", err)
+	}
+}
+
+// sendPage 把历史消息连同表情回应放进 page 发送，消息按时间正序
+func (cr *ChatRoom) sendPage(conn Conn, page *Message, history []db.ArchivedMessage) error {
+	reactions := cr.reactionsOf(history)
+	page.Messages = make([]*Message, 0, len(history))
+	for _, m := range history {
+		page.Messages = append(page.Messages, archivedMessage(m, reactions[m.Id]))
+	}
+	return conn.WriteMessage(page)
+}
@@ -0,0 +1,147 @@
+package msg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+	"slices"
+	"strings"
+)
+
+// maxMentions 一条消息最多提醒的用户数，多出的 @ 当作普通文字
+const maxMentions = 10
+
+// mentionInboxLimit 登录时最多展示的离线提醒条数
+const mentionInboxLimit = 50
+
+// mentionPunct @用户名 后面紧跟的标点不算用户名的一部分
+const mentionPunct = ",.!?:;，。！？：；、"
+
+// mentionsOf 解析群聊内容中以 @ 开头的用户名，去重，不包括发送者自己
+func mentionsOf(content string, sender string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Fields(content) {
+		name, ok := strings.CutPrefix(field, "@")
+		if !ok {
+			continue
+		}
+		name = strings.TrimRight(name, mentionPunct)
+		if name == "" || name == sender || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return names
+}
+
+// broadcastChat 广播群聊，被 @ 的用户改为收到提醒
+// 只提醒房间的成员，不在房间中的用户被 @ 也看不到房间里的消息
+func (cr *ChatRoom) broadcastChat(roomName string, message *Message, mentioned []string) {
+	if len(mentioned) == 0 {
+		cr.broadcast(roomName, message.Sender, message)
+		return
+	}
+	mention := *message
+	mention.Type = MessageMention
+	notified := make(map[string]bool)
+	cr.Mutex.Lock()
+	room, ok := cr.Rooms[roomName]
+	if !ok {
+		cr.Mutex.Unlock()
+		return
+	}
+	for _, username := range mentioned {
+		client, online := cr.Clients[username]
+		if _, member := room.Members[username]; !online || !member {
+			continue
+		}
+		notified[username] = true
+		if err := client.WriteMessage(&mention); err != nil {
+			log.Printf("提醒用户 %s 失败: %v\n", username, err)
+		}
+	}
+	cr.Mutex.Unlock()
+	cr.broadcastExcept(roomName, message, func(username string) bool {
+		return username == message.Sender || notified[username]
+	})
+}
+
+// storeMentions 被 @ 的用户已不在任何节点在线时存入其提醒收件箱
+// 只由发送者所在的节点处理，保证只存一次
+// 只存该用户所在房间的消息：默认房间登录后都会加入，其他房间以断线时所在的为准，免得把该用户看不到的房间消息通过提醒泄露出去
+func (cr *ChatRoom) storeMentions(msg *Message, mentioned []string) {
+	for _, username := range mentioned {
+		online, err := cr.presence.IsOnline(username)
+		if err != nil {
+			log.Printf("查询用户 %s 在线状态失败: %v", username, err)
+			continue
+		}
+		if online {
+			continue
+		}
+		if msg.Room != DefaultRoom {
+			rooms, err := cr.rooms.MemberRooms(username)
+			if err != nil {
+				log.Printf("查询用户 %s 所在的房间失败: %v", username, err)
+				continue
+			}
+			if !slices.Contains(rooms, msg.Room) {
+				continue
+			}
+		}
+		// 不存在的用户当作普通文字
+		if _, err = cr.users.SearchUser(username); err != nil {
+			if !errors.Is(err, db.ErrUserNotFound) {
+				log.Printf("查询用户 %s 失败: %v", username, err)
+			}
+			continue
+		}
+		if err = cr.messages.AddMention(username, msg.ID); err != nil {
+			log.Println("保存离线提醒失败:", err)
+			continue
+		}
+		fmt.Printf("%s 提到了 %s(离线暂存)\n", msg.Sender, username)
+	}
+}
+
+// sendMentions 登录后展示不在线期间提到该用户的消息，展示后清除
+func (cr *ChatRoom) sendMentions(username string, conn Conn) {
+	mentions, err := cr.messages.Mentions(username, mentionInboxLimit)
+	if err != nil {
+		log.Printf("查询用户 %s 的离线提醒失败: %v", username, err)
+		return
+	}
+	if len(mentions) == 0 {
+		return
+	}
+	reactions := cr.reactionsOf(mentions)
+	items := make([]*Message, 0, len(mentions))
+	for _, m := range mentions {
+		items = append(items, archivedMessage(m, reactions[m.Id]))
+	}
+	err = conn.WriteMessage(&Message{Type: MessageMention, Messages: items})
+	if err != nil {
+		log.Println("发送离线提醒失败:", err)
+		return
+	}
+	// 超出展示条数的旧提醒一并清除
+	if err = cr.messages.DeleteMentions(username, mentions[len(mentions)-1].Id); err != nil {
+		log.Println(err)
+	}
+}
+
+// isMentioned 内容中是否 @ 了该用户
+func isMentioned(content string, username string) bool {
+	for _, name := range mentionsOf(content, "") {
+		if name == username {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package msg
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMentionsOf(t *testing.T) {
+	many := make([]string, maxMentions+2)
+	for i := range many {
+		many[i] = fmt.Sprintf("@u%d", i)
+	}
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"没有提到", "hello world", nil},
+		{"一个用户", "@bob 你好", []string{"bob"}},
+		{"去掉后面的标点", "你好 @bob, @carol！", []string{"bob", "carol"}},
+		{"去重", "@bob @bob", []string{"bob"}},
+		{"不包括自己", "@alice @bob", []string{"bob"}},
+		{"单独的 @ 不算", "@ @，", nil},
+		{"不在开头的 @ 不算", "mail@bob", nil},
+		{"最多提醒 maxMentions 个", strings.Join(many, " "), func() []string {
+			var names []string
+			for _, m := range many[:maxMentions] {
+				names = append(names, m[1:])
+			}
+			return names
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mentionsOf(tt.content, "alice"); !slices.Equal(got, tt.want) {
+				t.Fatalf("mentionsOf(%q) = %v，应为 %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package msg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+	"slices"
+	"time"
+)
+
+// streams流中的控制指令
+const (
+	actionNotice = "notice" // 给用户发一条系统提示
+	actionKick   = "kick"   // 发送系统提示后踢下线
+)
+
+// sanctionNames 处罚类型的中文名
+var sanctionNames = map[string]string{
+	db.SanctionMute: "禁言",
+	db.SanctionBan:  "封禁",
+}
+
+// isModerator 是否是管理员
+func (cr *ChatRoom) isModerator(username string) bool {
+	return slices.Contains(cr.cfg.Server.Moderators, username)
+}
+
+// checkModeration 校验管理员命令，不通过时直接回复原因
+func (cr *ChatRoom) checkModeration(msg *Message) bool {
+	var reason string
+	switch {
+	case !cr.isModerator(msg.Sender):
+		reason = "只有管理员可以执行该操作"
+	case msg.Receiver == "":
+		reason = "请指定用户"
+	case cr.isModerator(msg.Receiver):
+		reason = "不能对管理员执行该操作"
+	case msg.Duration < 0:
+		reason = "时长不能为负数"
+	}
+	if reason == "" {
+		_, err := cr.users.SearchUser(msg.Receiver)
+		if errors.Is(err, db.ErrUserNotFound) {
+			reason = fmt.Sprintf("用户 %s 不存在", msg.Receiver)
+		} else if err != nil {
+			log.Printf("查询用户 %s 失败: %v", msg.Receiver, err)
+			reason = "操作失败，请稍后重试"
+		}
+	}
+	if reason != "" {
+		cr.replySystem(msg, reason)
+		return false
+	}
+	return true
+}
+
+// Kick 管理员把用户踢下线，用户可以重新登录
+func (cr *ChatRoom) Kick(msg *Message) {
+	if !cr.checkModeration(msg) {
+		return
+	}
+	online, err := cr.presence.IsOnline(msg.Receiver)
+	if err != nil {
+		log.Printf("查询用户 %s 在线状态失败: %v", msg.Receiver, err)
+		cr.replySystem(msg, "操作失败，请稍后重试")
+		return
+	}
+	if !online {
+		cr.replySystem(msg, fmt.Sprintf("用户 %s 不在线", msg.Receiver))
+		return
+	}
+	notice := fmt.Sprintf("你已被管理员 %s 踢下线", msg.Sender)
+	if msg.Content != "" {
+		notice += "，原因: " + msg.Content
+	}
+	cr.sendAction(actionKick, msg.Receiver, notice)
+	cr.replySystem(msg, fmt.Sprintf("已将 %s 踢下线", msg.Receiver))
+	fmt.Printf("管理员 %s 将 %s 踢下线\n", msg.Sender, msg.Receiver)
+}
+
+// Mute 管理员禁言用户，禁言期间不能发送群聊和私聊
+func (cr *ChatRoom) Mute(msg *Message) {
+	cr.sanction(msg, db.SanctionMute)
+}
+
+// Ban 管理员封禁用户，在线时立即踢下线，封禁期间不能登录
+func (cr *ChatRoom) Ban(msg *Message) {
+	cr.sanction(msg, db.SanctionBan)
+}
+
+// Unmute 管理员提前解除禁言
+func (cr *ChatRoom) Unmute(msg *Message) {
+	cr.lift(msg, db.SanctionMute)
+}
+
+// Unban 管理员提前解除封禁
+func (cr *ChatRoom) Unban(msg *Message) {
+	cr.lift(msg, db.SanctionBan)
+}
+
+// sanction 记录禁言或封禁并通知被处罚的用户
+func (cr *ChatRoom) sanction(msg *Message, kind string) {
+	if !cr.checkModeration(msg) {
+		return
+	}
+	now := time.Now()
+	sanction := db.Sanction{
+		Username:  msg.Receiver,
+		Kind:      kind,
+		Moderator: msg.Sender,
+		Reason:    msg.Content,
+		CreatedAt: now,
+	}
+	if msg.Duration > 0 {
+		expiresAt := now.Add(time.Duration(msg.Duration) * time.Second)
+		sanction.ExpiresAt = &expiresAt
+	}
+	if err := cr.sanctions.AddSanction(sanction); err != nil {
+		log.Println("记录处罚失败:", err)
+		cr.replySystem(msg, "操作失败，请稍后重试")
+		return
+	}
+	name := sanctionNames[kind]
+	action := actionNotice
+	if kind == db.SanctionBan {
+		action = actionKick
+	}
+	cr.sendAction(action, msg.Receiver, fmt.Sprintf("你已被管理员 %s %s%s", msg.Sender, name, sanctionText(&sanction)))
+	cr.replySystem(msg, fmt.Sprintf("已%s %s%s", name, msg.Receiver, sanctionText(&sanction)))
+	fmt.Printf("管理员 %s %s %s%s\n", msg.Sender, name, msg.Receiver, sanctionText(&sanction))
+}
+
+// lift 解除禁言或封禁
+func (cr *ChatRoom) lift(msg *Message, kind string) {
+	if !cr.checkModeration(msg) {
+		return
+	}
+	name := sanctionNames[kind]
+	removed, err := cr.sanctions.RemoveSanction(msg.Receiver, kind, time.Now())
+	if err != nil {
+		log.Println("解除处罚失败:", err)
+		cr.replySystem(msg, "操作失败，请稍后重试")
+		return
+	}
+	if !removed {
+		cr.replySystem(msg, fmt.Sprintf("用户 %s 没有被%s", msg.Receiver, name))
+		return
+	}
+	if kind == db.SanctionMute {
+		cr.sendAction(actionNotice, msg.Receiver, fmt.Sprintf("你的禁言已被管理员 %s 解除", msg.Sender))
+	}
+	cr.replySystem(msg, fmt.Sprintf("已解除 %s 的%s", msg.Receiver, name))
+	fmt.Printf("管理员 %s 解除了 %s 的%s\n", msg.Sender, msg.Receiver, name)
+}
+
+// sanctionText 处罚的期限和原因，如 "，10-18 15:04:05 解除，原因: 刷屏"
+func sanctionText(sanction *db.Sanction) string {
+	text := "，永久有效"
+	if sanction.ExpiresAt != nil {
+		text = fmt.Sprintf("，%s 解除", sanction.ExpiresAt.Format("01-02 15:04:05"))
+	}
+	if sanction.Reason != "" {
+		text += "，原因: " + sanction.Reason
+	}
+	return text
+}
+
+// sendAction 通过默认房间的streams流把控制指令送到用户所在的节点
+func (cr *ChatRoom) sendAction(action string, username string, content string) {
+	_, err := cr.streams.Append(DefaultRoom, db.StreamEntry{
+		Action:   action,
+		Sender:   "[系统]",
+		Receiver: username,
+		Content:  content,
+		Time:     time.Now(),
+	})
+	if err != nil {
+		log.Println("控制指令写入 streams 流失败:", err)
+	}
+}
+
+// dispatchAction 执行发给本节点用户的控制指令
+func (cr *ChatRoom) dispatchAction(entry db.StreamEntry) {
+	cr.Mutex.Lock()
+	client, ok := cr.Clients[entry.Receiver]
+	cr.Mutex.Unlock()
+	if !ok {
+		return
+	}
+	notice := &Message{Type: MessageChat, Sender: entry.Sender, Content: entry.Content}
+	switch entry.Action {
+	case actionKick:
+		// 连接关闭后读协程出错退出，由其调用 Leave；会话先注销，不能免密恢复
+		cr.endSession(client)
+		client.CloseAfter(notice)
+		log.Printf("用户 %s 被踢下线\n", entry.Receiver)
+	default:
+		if err := client.WriteMessage(notice); err != nil {
+			log.Println("dispatchAction:", err)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"onlineChatRoom/config"
+	"onlineChatRoom/db"
 	"onlineChatRoom/utils"
 	"strings"
 	"sync"
@@ -16,36 +18,92 @@ import (
 type MessageType int
 
 const (
-	MessageJoin     MessageType = iota //用户登录
-	MessageRegister                    //用户注册
-	MessageLeave                       //用户离线
-	MessageChat                        //聊天
-	MessagePrivate                     //私聊
-	MessageList                        //查看在线用户列表
-	MessageHeart                       //心跳检测
-	MessageRank                        //活跃度排行
+	MessageJoin         MessageType = iota //用户登录
+	MessageRegister                        //用户注册
+	MessageLeave                           //用户离线
+	MessageChat                            //聊天
+	MessagePrivate                         //私聊
+	MessageList                            //查看在线用户列表
+	MessageHeart                           //心跳检测
+	MessageRank                            //活跃度排行
+	MessageCreateRoom                      //创建房间
+	MessageJoinRoom                        //加入房间
+	MessageLeaveRoom                       //离开房间
+	MessageListRooms                       //查看房间列表
+	MessageAck                             //服务端已接收消息
+	MessageNack                            //服务端拒绝或未能接收消息
+	MessageKick                            //管理员踢人下线
+	MessageMute                            //管理员禁言
+	MessageBan                             //管理员封禁
+	MessageUnmute                          //管理员解除禁言
+	MessageUnban                           //管理员解除封禁
+	MessageResume                          //断线后凭会话令牌恢复登录
+	MessageFileUpload                      //开始或续传文件上传，服务端回复已收到的字节数
+	MessageFileChunk                       //上传文件的一块
+	MessageFileDownload                    //下载文件的一块
+	MessageEdit                            //编辑自己发送的消息，也用于推送编辑后的内容
+	MessageRecall                          //撤回自己发送的消息，也用于推送撤回
+	MessageDelete                          //管理员删除消息，也用于推送删除
+	MessageReact                           //对消息回应表情，也用于推送回应
+	MessageUnreact                         //取消表情回应，也用于推送取消
+	MessageThread                          //查看消息所在的整个话题
+	MessageMention                         //群聊中被 @ 的提醒，登录时也用于展示离线期间的提醒
+	MessageRead                            //客户端上报私聊已读，也用于向发送者推送已读回执
+	MessageReceipts                        //查询发出的私聊的送达和已读状态，或开启、关闭已读回执
+	MessageTyping                          //正在输入或停止输入，和心跳一样不写入streams流
+	MessageSearch                          //搜索聊天记录
+	MessageHistory                         //按页查询房间或私聊的历史消息，加入房间时也用于推送最近的历史
 )
 
 type Message struct {
-	Type     MessageType // 消息类型
-	Sender   string      // 发送者
-	Receiver string      // 接收者
-	Content  string      // 内容
-	Conn     net.Conn    // 发送者连接
-}
-
-// Client 客户端
-type Client struct {
-	Username      string
-	Conn          net.Conn
-	LastHeartbeat time.Time
+	Type        MessageType   // 消息类型
+	ID          int64         `json:",omitempty"` // 服务端分配的消息ID，编辑、撤回和删除时是目标消息的ID
+	Time        int64         `json:",omitempty"` // 服务端接收时间，毫秒时间戳
+	Seq         int64         `json:",omitempty"` // 客户端发送序号，ack/nack 原样带回
+	Code        string        `json:",omitempty"` // nack 的错误码
+	Duration    int64         `json:",omitempty"` // 禁言、封禁的时长，秒，0 表示永久
+	RetryAfter  int64         `json:",omitempty"` // 被限流时多久之后可以重试，毫秒
+	Token       string        `json:",omitempty"` // 登录会话令牌，登录成功时下发，恢复会话时带上
+	FileID      int64         `json:",omitempty"` // 文件ID
+	Offset      int64         `json:",omitempty"` // 文件分块的起始位置，上传时服务端回复已收到的字节数
+	Size        int64         `json:",omitempty"` // 文件大小，字节；服务端对上传的回复中是每块的大小上限
+	Checksum    string        `json:",omitempty"` // 文件的 sha256，十六进制
+	Data        []byte        `json:",omitempty"` // 文件分块的内容
+	Reactions   []db.Reaction `json:",omitempty"` // 推送表情回应时带上该消息汇总后的全部回应
+	ParentID    int64         `json:",omitempty"` // 回复的消息ID
+	Quote       string        `json:",omitempty"` // 回复的消息的摘要，如 "alice: 明天几点开会"
+	Messages    []*Message    `json:",omitempty"` // 查询结果中的消息列表，搜索结果按时间倒序，其余按时间正序
+	DeliveredAt int64         `json:",omitempty"` // 私聊送达接收者的时间，毫秒时间戳
+	ReadAt      int64         `json:",omitempty"` // 私聊被接收者读到的时间，毫秒时间戳
+	Limit       int64         `json:",omitempty"` // 查询的条数
+	Sender      string        // 发送者
+	Receiver    string        // 接收者
+	Content     string        // 内容
+	Room        string        // 所在房间
+	Conn        Conn          `json:"-"` // 发送者连接
 }
 
 // ChatRoom 聊天室
 type ChatRoom struct {
 	Clients map[string]*Client
+	Rooms   map[string]*Room
 	MsgChan chan *Message
 	Mutex   sync.Mutex
+	cfg     *config.Config
+
+	users     db.UserStore
+	messages  db.MessageStore
+	sanctions db.SanctionStore
+	streams   db.StreamStore
+	rank      db.RankStore
+	presence  db.PresenceStore
+	rooms     db.RoomStore
+	sessions  db.SessionStore
+	files     db.FileStore
+	reactions db.ReactionStore
+	events    db.EventStore
+	limiter   *rateLimiter
+	typing    *utils.Limiter // 正在输入事件的频率限制
 }
 
 func (msg *Message) JsonMessage() ([]byte, error) {
@@ -78,21 +136,61 @@ func SendJsonMessage(conn net.Conn, message *Message) error {
 	return utils.SendMessage(conn, jsonMessage)
 }
 
-func NewChatRoom() *ChatRoom {
+// NewChatRoom 创建聊天室，stores 决定用户、消息和排行存在哪里
+func NewChatRoom(cfg *config.Config, stores *db.Stores) *ChatRoom {
 	return &ChatRoom{
-		Clients: make(map[string]*Client),
-		MsgChan: make(chan *Message, 100),
+		Clients:   make(map[string]*Client),
+		Rooms:     map[string]*Room{DefaultRoom: newRoom(DefaultRoom, "")},
+		MsgChan:   make(chan *Message, 100),
+		cfg:       cfg,
+		users:     stores.Users,
+		messages:  stores.Messages,
+		sanctions: stores.Sanctions,
+		streams:   stores.Streams,
+		rank:      stores.Rank,
+		presence:  stores.Presence,
+		rooms:     stores.Rooms,
+		sessions:  stores.Sessions,
+		files:     stores.Files,
+		reactions: stores.Reactions,
+		events:    stores.Events,
+		limiter:   newRateLimiter(cfg.Server.RateLimit),
+		// 正常的客户端每隔 TypingInterval 才发一次，切换私聊对象或房间时会连着发几次
+		typing: utils.NewLimiter(TypingInterval/3, 3),
 	}
 }
 
+// nodeID 当前节点ID，在线状态中记录用户所在的节点
+func (cr *ChatRoom) nodeID() string {
+	return cr.cfg.Cluster.NodeID
+}
+
+// presenceTTL 在线状态的有效期，收到心跳时续期
+// 超过心跳超时加一个检测周期，心跳监控一定会先把失联的客户端踢下线；节点宕机时由过期兜底
+func (cr *ChatRoom) presenceTTL() time.Duration {
+	return cr.cfg.Heartbeat.Timeout + cr.cfg.Heartbeat.CheckInterval
+}
+
 func (cr *ChatRoom) AddClient(username string, client *Client) {
 	cr.Mutex.Lock()
 	defer cr.Mutex.Unlock()
 	cr.Clients[username] = client
 }
 
-func (cr *ChatRoom) RemoveClient(username string) {
+// RemoveClient 移除客户端及其房间成员身份，返回其所在的房间
+// 只有 client 仍是该用户当前的连接时才移除，避免旧连接断开时误删重新登录的新连接
+func (cr *ChatRoom) RemoveClient(client *Client) (rooms []string, removed bool) {
 	cr.Mutex.Lock()
 	defer cr.Mutex.Unlock()
-	delete(cr.Clients, username)
+	if cr.Clients[client.Username] != client {
+		return nil, false
+	}
+	delete(cr.Clients, client.Username)
+	for name, room := range cr.Rooms {
+		if _, ok := room.Members[client.Username]; ok {
+			delete(room.Members, client.Username)
+			rooms = append(rooms, name)
+		}
+	}
+	return rooms, true
 }
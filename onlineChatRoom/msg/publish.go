@@ -0,0 +1,194 @@
+package msg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+	"time"
+)
+
+// nack 错误码
+const (
+	CodeNotInRoom   = "not_in_room"  // 不在目标房间
+	CodeInternal    = "internal"     // 服务端内部错误
+	CodeNoUser      = "no_such_user" // 私聊的接收者不存在
+	CodeMuted       = "muted"        // 发送者被禁言
+	CodeRateLimited = "rate_limited" // 发送太频繁，RetryAfter 之后再试
+	CodeBadType     = "bad_type"     // 不支持的消息类型
+)
+
+// SendError 消息被拒绝的原因，Code 供客户端区分，Reason 直接展示给用户
+type SendError struct {
+	Code       string
+	Reason     string
+	RetryAfter time.Duration // 被限流时多久之后可以重试
+}
+
+func (e *SendError) Error() string {
+	return e.Reason
+}
+
+// Publish 聊天消息归档后写入所在房间的streams流，私聊统一写入默认房间的流
+// 回复发到被回复的消息所在的房间或私聊
+// 接收者不在任何节点在线的私聊直接暂存，不写入streams流
+// 活跃度只在这里统计一次，不随各节点的投递重复累加
+// 返回服务端分配的消息ID和接收时间
+func (cr *ChatRoom) Publish(msg *Message) (int64, time.Time, error) {
+	if err := cr.checkMuted(msg.Sender); err != nil {
+		return 0, time.Time{}, err
+	}
+	parent, err := cr.replyTo(msg)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	roomName := DefaultRoom
+	if msg.Receiver == "" {
+		if msg.Room != "" {
+			roomName = msg.Room
+		}
+		if !cr.isMember(roomName, msg.Sender) {
+			return 0, time.Time{}, &SendError{Code: CodeNotInRoom, Reason: fmt.Sprintf("你不在房间 %s 中，请先 join %s", roomName, roomName)}
+		}
+	}
+	now := time.Now()
+	archived := db.ArchivedMessage{
+		Room:      roomName,
+		Sender:    msg.Sender,
+		Receiver:  msg.Receiver,
+		Content:   msg.Content,
+		CreatedAt: now,
+	}
+	if parent != nil {
+		archived.ParentId, archived.ThreadId = parent.Id, threadOf(parent)
+	}
+	if msg.Receiver != "" {
+		// 私聊不属于任何房间
+		archived.Room = ""
+		online, err := cr.presence.IsOnline(msg.Receiver)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		if !online {
+			return cr.publishOffline(msg, archived)
+		}
+	}
+	// 先归档拿到消息ID
+	id, err := cr.messages.SaveMessage(archived)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	streamID, err := cr.streams.Append(roomName, db.StreamEntry{
+		ID:       id,
+		Sender:   msg.Sender,
+		Receiver: msg.Receiver,
+		Content:  msg.Content,
+		ParentID: archived.ParentId,
+		Quote:    quoteOf(parent),
+		Time:     now,
+	})
+	if err != nil {
+		// 没进streams流的消息不会被投递，也不应该出现在历史里
+		if delErr := cr.messages.DeleteMessage(id); delErr != nil {
+			log.Println(delErr)
+		}
+		return 0, time.Time{}, err
+	}
+	if err = cr.messages.UpdateStreamID(id, streamID); err != nil {
+		log.Println(err)
+	}
+	cr.addActivity(msg.Sender, 1)
+	return id, now, nil
+}
+
+// checkMuted 被禁言时返回 *SendError
+func (cr *ChatRoom) checkMuted(username string) error {
+	muted, err := cr.sanctions.ActiveSanction(username, db.SanctionMute, time.Now())
+	if err != nil {
+		return err
+	}
+	if muted != nil {
+		return &SendError{Code: CodeMuted, Reason: "你已被禁言" + sanctionText(muted)}
+	}
+	return nil
+}
+
+// publishOffline 归档并暂存发给离线用户的私聊
+func (cr *ChatRoom) publishOffline(msg *Message, archived db.ArchivedMessage) (int64, time.Time, error) {
+	if _, err := cr.users.SearchUser(msg.Receiver); err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return 0, time.Time{}, &SendError{Code: CodeNoUser, Reason: fmt.Sprintf("用户 %s 不存在", msg.Receiver)}
+		}
+		return 0, time.Time{}, err
+	}
+	id, err := cr.messages.SaveMessage(archived)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	msg.ID, msg.Time = id, archived.CreatedAt.UnixMilli()
+	if err = cr.storeOffline(msg); err != nil {
+		if delErr := cr.messages.DeleteMessage(id); delErr != nil {
+			log.Println(delErr)
+		}
+		return 0, time.Time{}, err
+	}
+	cr.addActivity(msg.Sender, 1)
+	return id, archived.CreatedAt, nil
+}
+
+// addActivity 增加活跃度，失败只记录日志
+func (cr *ChatRoom) addActivity(username string, number float64) {
+	if err := cr.rank.AddActivity(username, number); err != nil {
+		log.Printf("用户 %s 增加活跃度失败: %v", username, err)
+	}
+}
+
+// Ack 告知发送者消息已被服务端接收
+func Ack(msg *Message, id int64, sentAt time.Time) {
+	err := msg.Conn.WriteMessage(&Message{
+		Type: MessageAck,
+		ID:   id,
+		Time: sentAt.UnixMilli(),
+		Seq:  msg.Seq,
+	})
+	if err != nil {
+		log.Println("Ack send error:", err)
+	}
+}
+
+// Nack 告知发送者消息未被接收及原因
+func Nack(msg *Message, err error) {
+	rr := msg.Conn.WriteMessage(nackMessage(msg, err))
+	if rr != nil {
+		log.Println("Nack send error:", rr)
+	}
+}
+
+// nackMessage 构造 nack，不是 *SendError 的错误统一报服务端异常
+func nackMessage(msg *Message, err error) *Message {
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		log.Println("消息发送失败:", err)
+		sendErr = &SendError{Code: CodeInternal, Reason: "服务端异常，消息发送失败，请稍后重试"}
+	}
+	return &Message{
+		Type:       MessageNack,
+		Seq:        msg.Seq,
+		Code:       sendErr.Code,
+		RetryAfter: sendErr.RetryAfter.Milliseconds(),
+		Content:    sendErr.Reason,
+	}
+}
+
+// systemNotice 写入一条系统广播，exclude 是触发该广播的用户，不会收到
+func (cr *ChatRoom) systemNotice(roomName string, exclude string, content string) {
+	_, err := cr.streams.Append(roomName, db.StreamEntry{
+		Sender:   "系统广播",
+		Receiver: exclude,
+		Content:  content,
+		Time:     time.Now(),
+	})
+	if err != nil {
+		log.Println("系统广播写入 streams 流失败:", err)
+	}
+}
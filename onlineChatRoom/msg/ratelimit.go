@@ -0,0 +1,188 @@
+package msg
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"onlineChatRoom/config"
+	"onlineChatRoom/db"
+	"onlineChatRoom/utils"
+	"sync"
+	"time"
+)
+
+// rateLimiter 按用户和IP限流并记录超限次数，只统计连接在本节点的客户端
+type rateLimiter struct {
+	cfg   config.RateLimit
+	users map[MessageType]*utils.Limiter
+	ips   map[MessageType]*utils.Limiter
+
+	mu        sync.Mutex
+	strikes   map[string]*strike // 用户名 -> 超限记录
+	lastSweep time.Time
+}
+
+// strike 超限记录
+type strike struct {
+	count int
+	last  time.Time
+}
+
+func newRateLimiter(cfg config.RateLimit) *rateLimiter {
+	return &rateLimiter{
+		cfg:       cfg,
+		users:     limitersOf(cfg.User),
+		ips:       limitersOf(cfg.IP),
+		strikes:   make(map[string]*strike),
+		lastSweep: time.Now(),
+	}
+}
+
+// limitersOf 为每类受限的消息创建限流器
+func limitersOf(limits config.RateLimits) map[MessageType]*utils.Limiter {
+	return map[MessageType]*utils.Limiter{
+		MessageChat:    utils.NewLimiter(limits.Chat.Every, limits.Chat.Burst),
+		MessagePrivate: utils.NewLimiter(limits.Private.Every, limits.Private.Burst),
+		MessageList:    utils.NewLimiter(limits.List.Every, limits.List.Burst),
+		MessageRank:    utils.NewLimiter(limits.Rank.Every, limits.Rank.Burst),
+		// 查询话题、已读状态、搜索和历史消息都和查看在线用户同样限额
+		MessageThread:   utils.NewLimiter(limits.List.Every, limits.List.Burst),
+		MessageReceipts: utils.NewLimiter(limits.List.Every, limits.List.Burst),
+		MessageSearch:   utils.NewLimiter(limits.List.Every, limits.List.Burst),
+		MessageHistory:  utils.NewLimiter(limits.List.Every, limits.List.Burst),
+		// 编辑和表情回应会推送给所有接收者，和群聊同样限额
+		MessageEdit:    utils.NewLimiter(limits.Chat.Every, limits.Chat.Burst),
+		MessageReact:   utils.NewLimiter(limits.Chat.Every, limits.Chat.Burst),
+		MessageUnreact: utils.NewLimiter(limits.Chat.Every, limits.Chat.Burst),
+		// 撤回和删除同样会推送给所有接收者
+		MessageRecall: utils.NewLimiter(limits.Chat.Every, limits.Chat.Burst),
+		MessageDelete: utils.NewLimiter(limits.Chat.Every, limits.Chat.Burst),
+		// 文件的每一块都要读写磁盘，上传和下载按同样的限额
+		MessageFileUpload:   utils.NewLimiter(limits.File.Every, limits.File.Burst),
+		MessageFileChunk:    utils.NewLimiter(limits.File.Every, limits.File.Burst),
+		MessageFileDownload: utils.NewLimiter(limits.File.Every, limits.File.Burst),
+		MessageRead:         utils.NewLimiter(limits.Read.Every, limits.Read.Burst),
+	}
+}
+
+// paced 客户端自动发送、收到回复才发下一条的消息，超限时等到有额度再处理，不算超限次数
+// 拒绝文件块会中断传输，拒绝已读上报会丢失回执
+var paced = map[MessageType]bool{
+	MessageFileUpload:   true,
+	MessageFileChunk:    true,
+	MessageFileDownload: true,
+	MessageRead:         true,
+}
+
+// allow 用户和其IP都还有额度才放行，否则返回还需等待的时长
+func (l *rateLimiter) allow(messageType MessageType, username string, ip string, now time.Time) (bool, time.Duration) {
+	userLimiter, limited := l.users[messageType]
+	if !limited {
+		return true, 0
+	}
+	if ok, wait := userLimiter.Allow(username, now); !ok {
+		return false, wait
+	}
+	return l.ips[messageType].Allow(ip, now)
+}
+
+// strike 记录一次超限，返回 strikeWindow 内的累计次数
+func (l *rateLimiter) strike(username string, now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= l.cfg.StrikeWindow {
+		l.lastSweep = now
+		for name, s := range l.strikes {
+			if now.Sub(s.last) > l.cfg.StrikeWindow {
+				delete(l.strikes, name)
+			}
+		}
+	}
+	s, ok := l.strikes[username]
+	if !ok || now.Sub(s.last) > l.cfg.StrikeWindow {
+		s = &strike{}
+		l.strikes[username] = s
+	}
+	s.count++
+	s.last = now
+	return s.count
+}
+
+// forgive 处罚之后清零超限次数
+func (l *rateLimiter) forgive(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.strikes, username)
+}
+
+// Throttle 检查客户端的发送频率，超限时回复 nack 并返回 true，调用方应丢弃该消息
+// 前 warnings 次超限只警告，之后自动禁言或断开连接；paced 的消息只是等待，不会被丢弃
+func (cr *ChatRoom) Throttle(client *Client, msg *Message) bool {
+	now := time.Now()
+	ok, wait := cr.limiter.allow(msg.Type, client.Username, clientIP(client), now)
+	if ok {
+		return false
+	}
+	if paced[msg.Type] {
+		// 在该连接自己的读协程中等待，只会拖慢这个连接
+		for !ok {
+			time.Sleep(wait)
+			ok, wait = cr.limiter.allow(msg.Type, client.Username, clientIP(client), time.Now())
+		}
+		return false
+	}
+	strikes := cr.limiter.strike(client.Username, now)
+	warnings := cr.cfg.Server.RateLimit.Warnings
+	if strikes <= warnings {
+		Nack(msg, &SendError{
+			Code:       CodeRateLimited,
+			Reason:     fmt.Sprintf("发送太频繁，请 %.1f 秒后再试(警告 %d/%d)", wait.Seconds(), strikes, warnings),
+			RetryAfter: wait,
+		})
+		return true
+	}
+	cr.limiter.forgive(client.Username)
+	cr.punish(client, msg)
+	return true
+}
+
+// punish 自动处罚多次超限的用户，刷 list/rank 时禁言没用，直接断开
+func (cr *ChatRoom) punish(client *Client, msg *Message) {
+	cfg := cr.cfg.Server.RateLimit
+	if cfg.Penalty == config.PenaltyMute && (msg.Type == MessageChat || msg.Type == MessagePrivate) {
+		now := time.Now()
+		expiresAt := now.Add(cfg.MuteDuration)
+		sanction := db.Sanction{
+			Username:  client.Username,
+			Kind:      db.SanctionMute,
+			Moderator: "[系统]",
+			Reason:    "发送过于频繁",
+			CreatedAt: now,
+			ExpiresAt: &expiresAt,
+		}
+		err := cr.sanctions.AddSanction(sanction)
+		if err == nil {
+			Nack(msg, &SendError{
+				Code:       CodeRateLimited,
+				Reason:     "你已被自动禁言" + sanctionText(&sanction),
+				RetryAfter: cfg.MuteDuration,
+			})
+			log.Printf("用户 %s 发送过于频繁，自动禁言\n", client.Username)
+			return
+		}
+		// 禁言失败时退而断开连接
+		log.Println("自动禁言失败:", err)
+	}
+	cr.endSession(client)
+	client.CloseAfter(nackMessage(msg, &SendError{Code: CodeRateLimited, Reason: "发送过于频繁，已断开连接"}))
+	log.Printf("用户 %s 发送过于频繁，断开连接\n", client.Username)
+}
+
+// clientIP 客户端的IP
+func clientIP(client *Client) string {
+	addr := client.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
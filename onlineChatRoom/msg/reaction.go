@@ -0,0 +1,162 @@
+package msg
+
+import (
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxEmojiLength 表情或短标记的最大长度，字节
+const maxEmojiLength = 32
+
+// streams流中的表情回应，ID 是被回应的消息
+const (
+	actionReact   = "react"   // 回应
+	actionUnreact = "unreact" // 取消回应
+)
+
+// reactionTypes 表情回应推送给客户端时的消息类型
+var reactionTypes = map[string]MessageType{
+	actionReact:   MessageReact,
+	actionUnreact: MessageUnreact,
+}
+
+// React 对消息回应表情，msg.ID 是消息，msg.Content 是表情
+func (cr *ChatRoom) React(msg *Message) {
+	cr.react(msg, actionReact)
+}
+
+// Unreact 取消自己的表情回应
+func (cr *ChatRoom) Unreact(msg *Message) {
+	cr.react(msg, actionUnreact)
+}
+
+// react 记录或取消回应，再推送给能看到该消息的在线用户
+func (cr *ChatRoom) react(msg *Message, action string) {
+	emoji := msg.Content
+	if emoji == "" || len(emoji) > maxEmojiLength || strings.ContainsFunc(emoji, unicode.IsSpace) {
+		cr.replySystem(msg, fmt.Sprintf("表情不合法，不能为空、不能包含空格，且不能超过 %d 字节", maxEmojiLength))
+		return
+	}
+	m, ok := cr.findMessage(msg)
+	if !ok {
+		return
+	}
+	// 看不到的消息和不存在一样处理，不暴露私聊
+	if !cr.canSee(m, msg.Sender) {
+		cr.replySystem(msg, fmt.Sprintf("消息 #%d 不存在", m.Id))
+		return
+	}
+	var changed bool
+	var err error
+	if action == actionReact {
+		changed, err = cr.reactions.AddReaction(m.Id, emoji, msg.Sender)
+	} else {
+		changed, err = cr.reactions.RemoveReaction(m.Id, emoji, msg.Sender)
+	}
+	if err != nil {
+		log.Println("表情回应失败:", err)
+		cr.replySystem(msg, "操作失败，请稍后重试")
+		return
+	}
+	if !changed {
+		if action == actionReact {
+			cr.replySystem(msg, fmt.Sprintf("你已经用 %s 回应过消息 #%d", emoji, m.Id))
+		} else {
+			cr.replySystem(msg, fmt.Sprintf("你没有用 %s 回应过消息 #%d", emoji, m.Id))
+		}
+		return
+	}
+	cr.sendReaction(action, m, msg.Sender, emoji)
+}
+
+// canSee 群聊房间成员可以看到，私聊只有双方可以看到
+func (cr *ChatRoom) canSee(m *db.ArchivedMessage, username string) bool {
+	if m.Receiver != "" {
+		return username == m.Sender || username == m.Receiver
+	}
+	return cr.isMember(m.Room, username)
+}
+
+// sendReaction 通过消息所在的streams流把回应推送到各节点
+// Sender 是回应的用户，私聊的 Receiver 是另一方，群聊的 Receiver 为空
+func (cr *ChatRoom) sendReaction(action string, m *db.ArchivedMessage, username string, emoji string) {
+	roomName, other := m.Room, ""
+	if m.Receiver != "" {
+		roomName, other = DefaultRoom, m.Receiver
+		if username == m.Receiver {
+			other = m.Sender
+		}
+	}
+	_, err := cr.streams.Append(roomName, db.StreamEntry{
+		Action:   action,
+		ID:       m.Id,
+		Sender:   username,
+		Receiver: other,
+		Content:  emoji,
+		Time:     time.Now(),
+	})
+	if err != nil {
+		log.Println("表情回应写入 streams 流失败:", err)
+	}
+}
+
+// dispatchReaction 把回应连同该消息汇总后的全部回应推送给本节点在线的房间成员或私聊双方，回应者本人也会收到
+func (cr *ChatRoom) dispatchReaction(roomName string, entry db.StreamEntry) {
+	reactions, err := cr.reactions.Reactions([]int64{entry.ID})
+	if err != nil {
+		log.Println("查询表情回应失败:", err)
+	}
+	message := &Message{
+		Type:      reactionTypes[entry.Action],
+		ID:        entry.ID,
+		Sender:    entry.Sender,
+		Room:      roomName,
+		Content:   entry.Content,
+		Reactions: reactions[entry.ID],
+	}
+	if entry.Receiver == "" {
+		cr.broadcast(roomName, "", message)
+		return
+	}
+	message.Room = ""
+	cr.Mutex.Lock()
+	defer cr.Mutex.Unlock()
+	for _, username := range []string{entry.Sender, entry.Receiver} {
+		client, ok := cr.Clients[username]
+		if !ok {
+			continue
+		}
+		if err = client.WriteMessage(message); err != nil {
+			log.Println("dispatchReaction:", err)
+		}
+		if entry.Receiver == entry.Sender {
+			break
+		}
+	}
+}
+
+// reactionsOf 查询历史消息的回应，失败时不展示回应
+func (cr *ChatRoom) reactionsOf(messages []db.ArchivedMessage) map[int64][]db.Reaction {
+	ids := make([]int64, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.Id)
+	}
+	reactions, err := cr.reactions.Reactions(ids)
+	if err != nil {
+		log.Println("查询表情回应失败:", err)
+	}
+	return reactions
+}
+
+// reactionText 汇总的回应，如 "👍×2 ❤️×1"
+func reactionText(reactions []db.Reaction) string {
+	parts := make([]string, 0, len(reactions))
+	for _, r := range reactions {
+		parts = append(parts, fmt.Sprintf("%s×%d", r.Emoji, len(r.Users)))
+	}
+	return strings.Join(parts, " ")
+}
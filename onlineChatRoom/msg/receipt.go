@@ -0,0 +1,132 @@
+package msg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+	"time"
+)
+
+// receiptLimit 查询已读状态时列出的最近私聊条数
+const receiptLimit = 20
+
+// actionRead streams流中的已读回执，ID 是已读的私聊，Sender 是读到的用户，Receiver 是私聊的发送者
+const actionRead = "read"
+
+// MarkRead 接收者的客户端展示了私聊后上报，记下已读时间并把回执推送给发送者
+// 关闭了已读回执的用户上报的已读不记录也不推送
+func (cr *ChatRoom) MarkRead(msg *Message) {
+	m, err := cr.messages.GetMessage(msg.ID)
+	if err != nil {
+		if !errors.Is(err, db.ErrMessageNotFound) {
+			log.Printf("查询消息 #%d 失败: %v", msg.ID, err)
+		}
+		return
+	}
+	// 只有私聊的接收者能上报已读，客户端自动上报，不合法的直接忽略
+	if m.Receiver != msg.Sender || m.Sender == msg.Sender {
+		return
+	}
+	enabled, err := cr.users.ReadReceipts(msg.Sender)
+	if err != nil {
+		log.Printf("查询用户 %s 的已读回执设置失败: %v", msg.Sender, err)
+		return
+	}
+	if !enabled {
+		return
+	}
+	readAt := time.Now()
+	first, err := cr.messages.MarkRead(m.Id, readAt)
+	if err != nil {
+		log.Println("记录已读失败:", err)
+		return
+	}
+	if !first {
+		return
+	}
+	_, err = cr.streams.Append(DefaultRoom, db.StreamEntry{
+		Action:   actionRead,
+		ID:       m.Id,
+		Sender:   msg.Sender,
+		Receiver: m.Sender,
+		Time:     readAt,
+	})
+	if err != nil {
+		log.Println("已读回执写入 streams 流失败:", err)
+	}
+}
+
+// dispatchReceipt 把已读回执推送给连接在本节点的私聊发送者
+func (cr *ChatRoom) dispatchReceipt(entry db.StreamEntry) {
+	cr.Mutex.Lock()
+	defer cr.Mutex.Unlock()
+	client, ok := cr.Clients[entry.Receiver]
+	if !ok {
+		return
+	}
+	err := client.WriteMessage(&Message{
+		Type:   MessageRead,
+		ID:     entry.ID,
+		Time:   entry.Time.UnixMilli(),
+		Sender: entry.Sender,
+	})
+	if err != nil {
+		log.Println("dispatchReceipt:", err)
+	}
+}
+
+// markDelivered 记下私聊送达接收者的时间
+func (cr *ChatRoom) markDelivered(id int64) {
+	if id == 0 {
+		return
+	}
+	if err := cr.messages.MarkDelivered(id, time.Now()); err != nil {
+		log.Println("记录送达失败:", err)
+	}
+}
+
+// Receipts msg.Content 为 on/off 时开启或关闭已读回执，为空时查询最近发出的私聊的送达和已读状态
+func (cr *ChatRoom) Receipts(msg *Message) {
+	switch msg.Content {
+	case "on", "off":
+		if err := cr.users.SetReadReceipts(msg.Sender, msg.Content == "on"); err != nil {
+			log.Printf("修改用户 %s 的已读回执设置失败: %v", msg.Sender, err)
+			cr.replySystem(msg, "设置失败，请稍后重试")
+			return
+		}
+		if msg.Content == "on" {
+			cr.replySystem(msg, "已开启已读回执，对方会知道你读过了他的私聊")
+		} else {
+			cr.replySystem(msg, "已关闭已读回执，对方不会再知道你是否读过他的私聊")
+		}
+		return
+	case "":
+	default:
+		cr.replySystem(msg, "格式错误，应为 receipts [on|off]")
+		return
+	}
+	sent, err := cr.messages.SentPrivate(msg.Sender, receiptLimit)
+	if err != nil {
+		log.Printf("查询用户 %s 发出的私聊失败: %v", msg.Sender, err)
+		cr.replySystem(msg, "查询失败，请稍后重试")
+		return
+	}
+	items := make([]*Message, 0, len(sent))
+	for _, m := range sent {
+		item := archivedMessage(m, nil)
+		if m.DeliveredAt != nil {
+			item.DeliveredAt = m.DeliveredAt.UnixMilli()
+		}
+		if m.ReadAt != nil {
+			item.ReadAt = m.ReadAt.UnixMilli()
+		}
+		items = append(items, item)
+	}
+	err = msg.Conn.WriteMessage(&Message{Type: MessageReceipts, Messages: items})
+	if err != nil {
+		log.Println("发送已读状态失败:", err)
+		return
+	}
+	fmt.Println(msg.Sender, "查询私聊已读状态...")
+}
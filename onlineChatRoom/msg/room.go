@@ -0,0 +1,261 @@
+package msg
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultRoom 登录后默认加入的房间，私聊也走这个房间的streams流
+const DefaultRoom = "lobby"
+
+// maxRoomNameLength 房间名最大长度
+const maxRoomNameLength = 20
+
+// Room 房间
+type Room struct {
+	Name    string
+	Owner   string
+	Members map[string]struct{}
+}
+
+func newRoom(name string, owner string) *Room {
+	return &Room{
+		Name:    name,
+		Owner:   owner,
+		Members: make(map[string]struct{}),
+	}
+}
+
+// checkRoomName 校验房间名
+func checkRoomName(name string) error {
+	if name == "" {
+		return fmt.Errorf("房间名不能为空")
+	}
+	if utf8.RuneCountInString(name) > maxRoomNameLength {
+		return fmt.Errorf("房间名不能超过 %d 个字符", maxRoomNameLength)
+	}
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("房间名不能包含空白字符")
+	}
+	return nil
+}
+
+// CreateRoom 创建房间，创建者自动加入
+func (cr *ChatRoom) CreateRoom(msg *Message) {
+	if err := checkRoomName(msg.Room); err != nil {
+		cr.replySystem(msg, err.Error())
+		return
+	}
+	// 房间列表由所有节点共享，在存储中创建成功才算创建成功
+	created := false
+	if msg.Room != DefaultRoom {
+		var err error
+		created, err = cr.rooms.AddRoom(msg.Room, msg.Sender)
+		if err != nil {
+			log.Println("创建房间失败:", err)
+			cr.replySystem(msg, "创建房间失败，请稍后重试")
+			return
+		}
+	}
+	if !created {
+		cr.replySystem(msg, fmt.Sprintf("房间 %s 已存在", msg.Room))
+		return
+	}
+	// 消费组要在房间可以发言之前建好，否则建组之前写入的消息本节点读不到
+	cr.createGroup(msg.Room)
+	cr.Mutex.Lock()
+	room, ok := cr.Rooms[msg.Room]
+	if !ok {
+		room = newRoom(msg.Room, msg.Sender)
+		cr.Rooms[msg.Room] = room
+	}
+	room.Members[msg.Sender] = struct{}{}
+	cr.Mutex.Unlock()
+
+	rr := msg.Conn.WriteMessage(&Message{Type: MessageCreateRoom, Room: msg.Room, Content: "OK"})
+	if rr != nil {
+		log.Println("CreateRoom send error:", rr)
+	}
+	fmt.Println(msg.Sender, "创建了房间", msg.Room)
+}
+
+// JoinRoom 加入房间并推送该房间的历史消息
+func (cr *ChatRoom) JoinRoom(msg *Message) {
+	// 房间可能刚在其他节点创建
+	if !cr.hasRoom(msg.Room) {
+		cr.syncRooms()
+	}
+	cr.Mutex.Lock()
+	room, ok := cr.Rooms[msg.Room]
+	if !ok {
+		cr.Mutex.Unlock()
+		cr.replySystem(msg, fmt.Sprintf("房间 %s 不存在", msg.Room))
+		return
+	}
+	if _, joined := room.Members[msg.Sender]; joined {
+		cr.Mutex.Unlock()
+		cr.replySystem(msg, fmt.Sprintf("你已在房间 %s 中", msg.Room))
+		return
+	}
+	room.Members[msg.Sender] = struct{}{}
+	cr.Mutex.Unlock()
+
+	rr := msg.Conn.WriteMessage(&Message{Type: MessageJoinRoom, Room: msg.Room, Content: "OK"})
+	if rr != nil {
+		log.Println("JoinRoom send error:", rr)
+	}
+	cr.sendHistory(msg.Room, msg.Conn)
+	cr.systemNotice(msg.Room, msg.Sender, fmt.Sprintf("%s 加入了房间...", msg.Sender))
+}
+
+// LeaveRoom 离开房间
+func (cr *ChatRoom) LeaveRoom(msg *Message) {
+	cr.Mutex.Lock()
+	room, ok := cr.Rooms[msg.Room]
+	if ok {
+		_, ok = room.Members[msg.Sender]
+	}
+	if !ok {
+		cr.Mutex.Unlock()
+		cr.replySystem(msg, fmt.Sprintf("你不在房间 %s 中", msg.Room))
+		return
+	}
+	delete(room.Members, msg.Sender)
+	cr.Mutex.Unlock()
+
+	rr := msg.Conn.WriteMessage(&Message{Type: MessageLeaveRoom, Room: msg.Room, Content: "OK"})
+	if rr != nil {
+		log.Println("LeaveRoom send error:", rr)
+	}
+	cr.systemNotice(msg.Room, msg.Sender, fmt.Sprintf("%s 离开了房间...", msg.Sender))
+}
+
+// ShowRooms 查看房间列表，带 * 的是已加入的房间，人数只统计连接在本节点的成员
+func (cr *ChatRoom) ShowRooms(msg *Message) {
+	cr.syncRooms()
+	cr.Mutex.Lock()
+	names := make([]string, 0, len(cr.Rooms))
+	for name := range cr.Rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := "房间列表: "
+	for _, name := range names {
+		room := cr.Rooms[name]
+		mark := ""
+		if _, ok := room.Members[msg.Sender]; ok {
+			mark = "*"
+		}
+		list += fmt.Sprintf("%s(%d人)%s  ", name, len(room.Members), mark)
+	}
+	cr.Mutex.Unlock()
+
+	err := msg.Conn.WriteMessage(&Message{Type: MessageListRooms, Content: list})
+	if err != nil {
+		log.Println("ShowRooms ", err)
+	}
+}
+
+// hasRoom 本节点是否已加载该房间
+func (cr *ChatRoom) hasRoom(name string) bool {
+	cr.Mutex.Lock()
+	defer cr.Mutex.Unlock()
+	_, ok := cr.Rooms[name]
+	return ok
+}
+
+// syncRooms 加载其他节点创建的房间
+func (cr *ChatRoom) syncRooms() {
+	rooms, err := cr.rooms.Rooms()
+	if err != nil {
+		log.Println("查询房间列表失败:", err)
+		return
+	}
+	for name, owner := range rooms {
+		if cr.hasRoom(name) {
+			continue
+		}
+		cr.createGroup(name)
+		cr.Mutex.Lock()
+		if _, ok := cr.Rooms[name]; !ok {
+			cr.Rooms[name] = newRoom(name, owner)
+		}
+		cr.Mutex.Unlock()
+	}
+}
+
+// createGroup 为房间创建本节点的消费组，失败时 HandleStreams 读取时会再次创建
+func (cr *ChatRoom) createGroup(roomName string) {
+	group, _ := cr.streamGroup()
+	if err := cr.streams.CreateGroup(group, roomName); err != nil {
+		log.Printf("创建房间 %s 的消费组失败: %v", roomName, err)
+	}
+}
+
+// roomsOf 查询用户加入的所有房间
+func (cr *ChatRoom) roomsOf(username string) []string {
+	cr.Mutex.Lock()
+	defer cr.Mutex.Unlock()
+	var names []string
+	for name, room := range cr.Rooms {
+		if _, ok := room.Members[username]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// isMember 判断用户是否在房间中
+func (cr *ChatRoom) isMember(roomName string, username string) bool {
+	cr.Mutex.Lock()
+	defer cr.Mutex.Unlock()
+	room, ok := cr.Rooms[roomName]
+	if !ok {
+		return false
+	}
+	_, ok = room.Members[username]
+	return ok
+}
+
+// roomNames 本节点已加载的所有房间
+func (cr *ChatRoom) roomNames() []string {
+	cr.Mutex.Lock()
+	defer cr.Mutex.Unlock()
+	names := make([]string, 0, len(cr.Rooms))
+	for name := range cr.Rooms {
+		names = append(names, name)
+	}
+	return names
+}
+
+// sendHistory 加入房间时发送最近的历史消息及其表情回应，和 history 命令的结果格式一样
+func (cr *ChatRoom) sendHistory(roomName string, conn Conn) {
+	limit := cr.cfg.Server.HistoryLimit
+	if limit == 0 {
+		return
+	}
+	history, err := cr.messages.History(roomName, 0, limit)
+	if err != nil {
+		log.Println(err)
+	}
+	err = cr.sendPage(conn, &Message{Type: MessageHistory, Room: roomName, Limit: limit}, history)
+	if err != nil {
+		log.Println("发送历史消息失败:", err)
+	}
+}
+
+// replySystem 给请求方回复系统提示
+func (cr *ChatRoom) replySystem(msg *Message, content string) {
+	err := msg.Conn.WriteMessage(&Message{
+		Type:    MessageChat,
+		Sender:  "[系统]",
+		Content: content,
+	})
+	if err != nil {
+		log.Println("replySystem:", err)
+	}
+}
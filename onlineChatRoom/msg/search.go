@@ -0,0 +1,127 @@
+package msg
+
+import (
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// searchPageSize 搜索结果每页条数
+const searchPageSize = 10
+
+// 搜索的关键词个数和每个关键词的长度上限
+const (
+	maxSearchKeywords = 5
+	maxKeywordLength  = 50
+)
+
+// searchDate 搜索条件中日期的格式
+const searchDate = "2006-01-02"
+
+// searchUsage 搜索命令的格式
+const searchUsage = "格式: search 关键词... [from:发送者] [in:房间 或 with:私聊对象] [since:2006-01-02] [until:2006-01-02] [page:页码]"
+
+// parseSearch 解析搜索条件，since 和 until 都包含当天，返回条件和页码
+func parseSearch(username string, text string) (db.SearchQuery, int64, error) {
+	q := db.SearchQuery{Username: username, Limit: searchPageSize}
+	page := int64(1)
+	for _, field := range strings.Fields(text) {
+		name, value, ok := strings.Cut(field, ":")
+		if !ok || value == "" {
+			q.Keywords = append(q.Keywords, field)
+			continue
+		}
+		var err error
+		switch name {
+		case "from":
+			q.Sender = value
+		case "in":
+			q.Room = value
+		case "with":
+			q.With = value
+		case "since":
+			q.Since, err = time.ParseInLocation(searchDate, value, time.Local)
+		case "until":
+			q.Until, err = time.ParseInLocation(searchDate, value, time.Local)
+			q.Until = q.Until.AddDate(0, 0, 1)
+		case "page":
+			page, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && page <= 0 {
+				err = fmt.Errorf("页码必须大于0")
+			}
+		default:
+			// 不是过滤条件的当作关键词，如 "12:30"
+			q.Keywords = append(q.Keywords, field)
+		}
+		if err != nil {
+			return q, 0, fmt.Errorf("%s 不合法，%s", field, searchUsage)
+		}
+	}
+	switch {
+	case len(q.Keywords) == 0:
+		return q, 0, fmt.Errorf("请输入要搜索的关键词，%s", searchUsage)
+	case len(q.Keywords) > maxSearchKeywords:
+		return q, 0, fmt.Errorf("关键词最多 %d 个", maxSearchKeywords)
+	case q.Room != "" && q.With != "":
+		return q, 0, fmt.Errorf("in: 和 with: 不能同时使用")
+	case !q.Since.IsZero() && !q.Until.IsZero() && !q.Since.Before(q.Until):
+		return q, 0, fmt.Errorf("since: 不能晚于 until:")
+	}
+	for _, keyword := range q.Keywords {
+		if utf8.RuneCountInString(keyword) > maxKeywordLength {
+			return q, 0, fmt.Errorf("关键词不能超过 %d 个字", maxKeywordLength)
+		}
+	}
+	q.Offset = (page - 1) * searchPageSize
+	return q, page, nil
+}
+
+// Search 在全部聊天记录中搜索，msg.Content 是关键词和过滤条件，结果按时间倒序分页
+// 私聊只有收发双方能搜到，群聊只能搜到搜索者当前所在的房间
+func (cr *ChatRoom) Search(msg *Message) {
+	q, page, err := parseSearch(msg.Sender, msg.Content)
+	if err != nil {
+		cr.replySystem(msg, err.Error())
+		return
+	}
+	q.Rooms = cr.roomsOf(msg.Sender)
+	if q.Room != "" && !slices.Contains(q.Rooms, q.Room) {
+		cr.replySystem(msg, fmt.Sprintf("你不在房间 %s 中", q.Room))
+		return
+	}
+	found, total, err := cr.messages.Search(q)
+	if err != nil {
+		log.Printf("用户 %s 搜索失败: %v", msg.Sender, err)
+		cr.replySystem(msg, "搜索失败，请稍后重试")
+		return
+	}
+	if total == 0 {
+		cr.replySystem(msg, "没有找到相关的消息")
+		return
+	}
+	pages := (total + searchPageSize - 1) / searchPageSize
+	if page > pages {
+		cr.replySystem(msg, fmt.Sprintf("共 %d 条，只有 %d 页", total, pages))
+		return
+	}
+	reactions := cr.reactionsOf(found)
+	items := make([]*Message, 0, len(found))
+	for _, m := range found {
+		items = append(items, archivedMessage(m, reactions[m.Id]))
+	}
+	err = msg.Conn.WriteMessage(&Message{
+		Type:     MessageSearch,
+		Content:  fmt.Sprintf("共 %d 条，第 %d/%d 页", total, page, pages),
+		Messages: items,
+	})
+	if err != nil {
+		log.Println("发送搜索结果失败:", err)
+		return
+	}
+	fmt.Printf("%s 搜索: %s\n", msg.Sender, msg.Content)
+}
@@ -0,0 +1,68 @@
+package msg
+
+import (
+	"onlineChatRoom/db"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSearch(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.ParseInLocation(searchDate, s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		name     string
+		text     string
+		keywords []string
+		check    func(q db.SearchQuery) bool
+		page     int64
+		wantErr  string
+	}{
+		{"只有关键词", "hello world", []string{"hello", "world"}, nil, 1, ""},
+		{"过滤条件", "hi from:bob in:dev page:3", []string{"hi"},
+			func(q db.SearchQuery) bool { return q.Sender == "bob" && q.Room == "dev" }, 3, ""},
+		{"私聊对象", "hi with:bob", []string{"hi"}, func(q db.SearchQuery) bool { return q.With == "bob" }, 1, ""},
+		{"日期都包含当天", "hi since:2024-05-01 until:2024-05-02", []string{"hi"},
+			func(q db.SearchQuery) bool {
+				return q.Since.Equal(day("2024-05-01")) && q.Until.Equal(day("2024-05-03"))
+			}, 1, ""},
+		{"不认识的前缀当作关键词", "12:30 at:home", []string{"12:30", "at:home"}, nil, 1, ""},
+		{"冒号后为空当作关键词", "from: hi", []string{"from:", "hi"}, nil, 1, ""},
+		{"没有关键词", "from:bob", nil, nil, 0, "请输入要搜索的关键词"},
+		{"关键词太多", "a b c d e f", nil, nil, 0, "关键词最多"},
+		{"关键词太长", strings.Repeat("字", maxKeywordLength+1), nil, nil, 0, "关键词不能超过"},
+		{"in 和 with 同时使用", "hi in:dev with:bob", nil, nil, 0, "不能同时使用"},
+		{"日期格式错误", "hi since:05-01", nil, nil, 0, "since:05-01 不合法"},
+		{"since 晚于 until", "hi since:2024-05-03 until:2024-05-01", nil, nil, 0, "since: 不能晚于 until:"},
+		{"页码为0", "hi page:0", nil, nil, 0, "page:0 不合法"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, page, err := parseSearch("alice", tt.text)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v，应包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Username != "alice" || !slices.Equal(q.Keywords, tt.keywords) || page != tt.page {
+				t.Fatalf("解析结果 = %+v 第 %d 页", q, page)
+			}
+			if q.Limit != searchPageSize || q.Offset != (page-1)*searchPageSize {
+				t.Fatalf("分页 = offset %d limit %d", q.Offset, q.Limit)
+			}
+			if tt.check != nil && !tt.check(q) {
+				t.Fatalf("过滤条件 = %+v", q)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package msg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+	"onlineChatRoom/utils"
+	"slices"
+)
+
+// CodeSessionExpired 恢复会话失败时的错误码，会话已失效，只能重新输入密码登录
+const CodeSessionExpired = "session_expired"
+
+// newSession 生成令牌并保存会话，失败时令牌为空，只是不能免密恢复，不影响登录
+func (cr *ChatRoom) newSession(session *db.Session) {
+	token, err := utils.NewToken()
+	if err != nil {
+		log.Printf("生成用户 %s 的会话令牌失败: %v", session.Username, err)
+		return
+	}
+	session.Token = token
+	if err = cr.sessions.SaveSession(*session, cr.cfg.Server.SessionTTL); err != nil {
+		log.Printf("保存用户 %s 的会话失败: %v", session.Username, err)
+		session.Token = ""
+	}
+}
+
+// keepSession 断开连接时记下所在的房间并重置有效期，主动退出或被踢下线时不保留
+func (cr *ChatRoom) keepSession(client *Client, rooms []string) {
+	if client.session.Token == "" || client.sessionEnded.Load() {
+		return
+	}
+	session := client.session
+	session.Rooms = rooms
+	if err := cr.sessions.SaveSession(session, cr.cfg.Server.SessionTTL); err != nil {
+		log.Printf("保存用户 %s 的会话失败: %v", client.Username, err)
+	}
+	// 离线期间在这些房间中被 @ 也要存入提醒收件箱
+	if err := cr.rooms.SaveMemberRooms(client.Username, rooms, cr.cfg.Server.SessionTTL); err != nil {
+		log.Printf("保存用户 %s 所在的房间失败: %v", client.Username, err)
+	}
+}
+
+// endSession 注销会话，之后断开连接不能再免密恢复，可重复调用
+func (cr *ChatRoom) endSession(client *Client) {
+	if !client.sessionEnded.CompareAndSwap(false, true) || client.session.Token == "" {
+		return
+	}
+	if err := cr.sessions.DeleteSession(client.session.Token); err != nil {
+		log.Printf("注销用户 %s 的会话失败: %v", client.Username, err)
+	}
+	if err := cr.rooms.SaveMemberRooms(client.Username, nil, 0); err != nil {
+		log.Printf("清除用户 %s 所在的房间失败: %v", client.Username, err)
+	}
+}
+
+// Logout 主动退出，注销会话后断开连接
+func (cr *ChatRoom) Logout(client *Client) {
+	cr.endSession(client)
+	cr.Leave(client)
+}
+
+// Resume 凭会话令牌免密恢复登录，回到断线前的房间并补发错过的消息，成功返回带发送队列的客户端，失败返回 nil
+// msg.ID 是客户端最后收到的消息ID
+func (cr *ChatRoom) Resume(msg *Message) *Client {
+	// 服务端可能还没发现旧连接已断开，令牌相同说明是同一个客户端，直接顶替
+	cr.Mutex.Lock()
+	old, ok := cr.Clients[msg.Sender]
+	cr.Mutex.Unlock()
+	if ok && msg.Token != "" && old.session.Token == msg.Token {
+		cr.Leave(old)
+	}
+	session, err := cr.sessions.GetSession(msg.Token)
+	if err != nil || (msg.Sender != "" && msg.Sender != session.Username) {
+		respCode, respContent := CodeSessionExpired, "会话已失效，请重新登录"
+		if err != nil && !errors.Is(err, db.ErrSessionNotFound) {
+			respCode, respContent = CodeInternal, "恢复会话失败，请稍后重试"
+			log.Printf("查询会话失败: %v", err)
+		}
+		if r := msg.Conn.WriteMessage(&Message{
+			Type:    MessageChat,
+			Code:    respCode,
+			Content: respContent,
+		}); r != nil {
+			log.Println("发送恢复会话失败响应错误:", r)
+		}
+		return nil
+	}
+	msg.Sender = session.Username
+	if !cr.admit(msg) {
+		return nil
+	}
+	rr := msg.Conn.WriteMessage(&Message{
+		Type:    MessageResume,
+		Sender:  session.Username,
+		Content: "OK",
+		Token:   session.Token,
+	})
+	if rr != nil {
+		log.Println("Resume send error:", rr)
+		cr.releasePresence(session.Username)
+		return nil
+	}
+	client := NewClient(session.Username, msg.Conn, cr.cfg.Server.SendQueueSize, cr.cfg.Server.WriteTimeout)
+	client.session = *session
+	cr.AddClient(session.Username, client)
+	msg.Conn = client
+
+	rooms := cr.rejoin(session.Username, session.Rooms)
+	for _, name := range rooms {
+		// 客户端据此恢复已加入的房间
+		if name == DefaultRoom {
+			continue
+		}
+		if r := client.WriteMessage(&Message{Type: MessageJoinRoom, Room: name, Content: "OK"}); r != nil {
+			log.Println("Resume send error:", r)
+		}
+	}
+	// 已不存在的房间告知客户端离开，否则客户端还会往里面发言
+	for _, name := range droppedRooms(session.Rooms, rooms) {
+		if r := client.WriteMessage(&Message{Type: MessageLeaveRoom, Room: name, Content: fmt.Sprintf("房间 %s 已不存在", name)}); r != nil {
+			log.Println("Resume send error:", r)
+		}
+	}
+	cr.sendMissed(client, rooms, msg.ID)
+	for _, name := range rooms {
+		cr.systemNotice(name, session.Username, fmt.Sprintf("%s 重新连接...", session.Username))
+	}
+	fmt.Println(session.Username, "恢复会话...")
+	return client
+}
+
+// rejoin 重新加入断线前的房间，已不存在的房间跳过，返回加入的房间
+func (cr *ChatRoom) rejoin(username string, rooms []string) []string {
+	for _, name := range rooms {
+		if !cr.hasRoom(name) {
+			// 房间可能是在其他节点创建的
+			cr.syncRooms()
+			break
+		}
+	}
+	cr.Mutex.Lock()
+	defer cr.Mutex.Unlock()
+	joined := make([]string, 0, len(rooms))
+	for _, name := range rooms {
+		room, ok := cr.Rooms[name]
+		if !ok {
+			continue
+		}
+		room.Members[username] = struct{}{}
+		joined = append(joined, name)
+	}
+	return joined
+}
+
+// droppedRooms before 中没能重新加入的房间
+func droppedRooms(before []string, joined []string) []string {
+	var dropped []string
+	for _, name := range before {
+		if !slices.Contains(joined, name) {
+			dropped = append(dropped, name)
+		}
+	}
+	return dropped
+}
+
+// sendMissed 按顺序补发 afterID 之后错过的群聊和私聊，格式和实时收到的一致
+// 客户端以消息ID去重，恢复期间实时收到的消息和补发的重复也没关系
+func (cr *ChatRoom) sendMissed(client *Client, rooms []string, afterID int64) {
+	limit := cr.cfg.Server.ResumeLimit
+	missed, err := cr.messages.Missed(client.Username, rooms, afterID, client.session.LoginAt, limit)
+	if err != nil {
+		log.Printf("查询用户 %s 错过的消息失败: %v", client.Username, err)
+		cr.replySystem(&Message{Conn: client}, "补发断线期间的消息失败")
+		return
+	}
+	if int64(len(missed)) == limit {
+		cr.replySystem(&Message{Conn: client}, fmt.Sprintf("断线期间的消息较多，只补发最近的 %d 条", limit))
+	}
+	// 客户端已经收到 afterID 之前的消息，补发成功一条就往后推一条
+	lastID := afterID
+	for _, m := range missed {
+		message := &Message{
+			Type:     MessageChat,
+			ID:       m.Id,
+			Time:     m.CreatedAt.UnixMilli(),
+			Sender:   m.Sender,
+			Room:     m.Room,
+			Content:  fmt.Sprintf("[%s] %s: %s", m.Room, m.Sender, displayContent(m)),
+			ParentID: m.ParentId,
+		}
+		if m.Receiver != "" {
+			message.Type = MessagePrivate
+			message.Room = ""
+			message.Content = displayContent(m)
+		} else if isMentioned(m.Content, client.Username) {
+			message.Type = MessageMention
+		}
+		if err = client.WriteMessage(message); err != nil {
+			log.Println("补发消息失败:", err)
+			break
+		}
+		if m.Receiver == client.Username {
+			cr.markDelivered(m.Id)
+		}
+		lastID = m.Id
+	}
+	// 离线暂存的私聊也在归档里，已经补发过的不再重复投递
+	// 补发之后才暂存的和没补发成功的留到下次登录再投递
+	offline, err := cr.messages.ListOffline(client.Username)
+	if err != nil {
+		log.Println(err)
+	} else if id := sentOffline(offline, lastID); id != 0 {
+		if err = cr.messages.DeleteOffline(client.Username, id); err != nil {
+			log.Println(err)
+		}
+	}
+	// 断线期间的提醒同理
+	if err = cr.messages.DeleteMentions(client.Username, lastID); err != nil {
+		log.Println(err)
+	}
+}
+
+// sentOffline 按暂存顺序，消息ID都不大于 lastID 的离线私聊已经补发过，返回其中最后一条的暂存ID，没有时返回 0
+// 暂存的顺序和消息ID的顺序不一定一致，遇到没补发的就停下，不会删掉它
+func sentOffline(offline []db.OfflineMessage, lastID int64) int64 {
+	var id int64
+	for _, m := range offline {
+		if m.MessageId > lastID {
+			break
+		}
+		id = m.Id
+	}
+	return id
+}
@@ -0,0 +1,72 @@
+package msg
+
+import (
+	"onlineChatRoom/db"
+	"strings"
+	"testing"
+)
+
+func TestSentOffline(t *testing.T) {
+	offline := []db.OfflineMessage{{Id: 1, MessageId: 10}, {Id: 2, MessageId: 12}, {Id: 3, MessageId: 11}, {Id: 4, MessageId: 13}}
+	tests := []struct {
+		name   string
+		lastID int64
+		want   int64
+	}{
+		{"都没补发", 9, 0},
+		{"补发了一部分", 10, 1},
+		{"暂存顺序和消息ID不一致时停在没补发的前面", 11, 1},
+		{"都补发了", 13, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sentOffline(offline, tt.lastID); got != tt.want {
+				t.Fatalf("sentOffline(%d) = %d，应为 %d", tt.lastID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResumeLimit(t *testing.T) {
+	cr := newTestRoom(t)
+	cr.cfg.Server.ResumeLimit = 2
+	alice, _ := login(t, cr, "alice")
+	register(t, cr, "bob")
+
+	conn := newFakeConn()
+	bob := cr.Join(&Message{Type: MessageJoin, Sender: "bob", Content: "pw", Conn: conn})
+	if bob == nil {
+		t.Fatal("bob 登录失败")
+	}
+	token := conn.next(t, ofType(MessageRegister)).Token
+	cr.Leave(bob)
+
+	var ids []int64
+	for _, content := range []string{"one", "two", "three"} {
+		id, _, err := cr.Publish(&Message{Type: MessagePrivate, Sender: "alice", Receiver: "bob", Content: content, Conn: alice})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	conn = newFakeConn()
+	if cr.Resume(&Message{Type: MessageResume, Sender: "bob", Token: token, Conn: conn}) == nil {
+		t.Fatal("bob 恢复会话失败")
+	}
+	if reply := conn.next(t, fromSystem); !strings.Contains(reply.Content, "只补发最近的 2 条") {
+		t.Fatalf("bob 收到 %q，应提示只补发最近的消息", reply.Content)
+	}
+	for _, id := range ids[1:] {
+		if got := conn.next(t, ofType(MessagePrivate)); got.ID != id {
+			t.Fatalf("补发的私聊 = %+v，应为消息 %d", got, id)
+		}
+	}
+	offline, err := cr.messages.ListOffline("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offline) != 0 {
+		t.Fatalf("补发后仍暂存 %d 条离线私聊", len(offline))
+	}
+}
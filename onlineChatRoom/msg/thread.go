@@ -0,0 +1,129 @@
+package msg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+)
+
+// CodeNoMessage 回复的消息不存在或无权查看
+const CodeNoMessage = "no_such_message"
+
+// quoteLength 回复时引用的摘要长度，字符
+const quoteLength = 30
+
+// replyTo 查询回复的消息，不是回复时返回 nil
+// 回复发到被回复的消息所在的房间或私聊，msg 的房间和接收者随之修改
+func (cr *ChatRoom) replyTo(msg *Message) (*db.ArchivedMessage, error) {
+	if msg.ParentID == 0 {
+		return nil, nil
+	}
+	parent, err := cr.messages.GetMessage(msg.ParentID)
+	if err != nil && !errors.Is(err, db.ErrMessageNotFound) {
+		return nil, err
+	}
+	// 看不到的消息和不存在一样处理，不暴露私聊
+	if parent == nil || !cr.canSee(parent, msg.Sender) {
+		return nil, &SendError{Code: CodeNoMessage, Reason: fmt.Sprintf("消息 #%d 不存在", msg.ParentID)}
+	}
+	msg.Room, msg.Receiver = parent.Room, ""
+	if parent.Receiver != "" {
+		msg.Type, msg.Room, msg.Receiver = MessagePrivate, "", parent.Receiver
+		if msg.Sender == parent.Receiver {
+			msg.Receiver = parent.Sender
+		}
+	}
+	return parent, nil
+}
+
+// threadOf 消息所在话题的ID，不是回复的消息自己就是话题的第一条
+func threadOf(m *db.ArchivedMessage) int64 {
+	if m.ThreadId != 0 {
+		return m.ThreadId
+	}
+	return m.Id
+}
+
+// quoteOf 引用的摘要，如 "alice: 明天几点开会"
+func quoteOf(m *db.ArchivedMessage) string {
+	if m == nil {
+		return ""
+	}
+	content := []rune(displayContent(*m))
+	if len(content) > quoteLength {
+		return m.Sender + ": " + string(content[:quoteLength]) + "..."
+	}
+	return m.Sender + ": " + string(content)
+}
+
+// ShowThread 发送 msg.ID 所在的整个话题：第一条消息和最新的 threadLimit 条回复
+func (cr *ChatRoom) ShowThread(msg *Message) {
+	m, ok := cr.visibleMessage(msg, msg.ID)
+	if !ok {
+		return
+	}
+	root := m
+	if m.ThreadId != 0 {
+		if root, ok = cr.visibleMessage(msg, m.ThreadId); !ok {
+			return
+		}
+	}
+	replies, err := cr.messages.Thread(root.Id, cr.cfg.Server.ThreadLimit)
+	if err != nil {
+		log.Println("查询话题失败:", err)
+		cr.replySystem(msg, "查询话题失败，请稍后重试")
+		return
+	}
+	all := append([]db.ArchivedMessage{*root}, replies...)
+	byID := make(map[int64]*db.ArchivedMessage, len(all))
+	for i := range all {
+		byID[all[i].Id] = &all[i]
+	}
+	reactions := cr.reactionsOf(all)
+	items := make([]*Message, 0, len(all))
+	for _, a := range all {
+		item := archivedMessage(a, reactions[a.Id])
+		// 更早的回复不在结果中时只给出ID
+		item.Quote = quoteOf(byID[a.ParentId])
+		items = append(items, item)
+	}
+	err = msg.Conn.WriteMessage(&Message{Type: MessageThread, ID: root.Id, Messages: items})
+	if err != nil {
+		log.Println("ShowThread send error:", err)
+	}
+}
+
+// visibleMessage 查询用户可以看到的消息，不存在或看不到时直接回复
+func (cr *ChatRoom) visibleMessage(msg *Message, id int64) (*db.ArchivedMessage, bool) {
+	m, err := cr.messages.GetMessage(id)
+	if err != nil && !errors.Is(err, db.ErrMessageNotFound) {
+		log.Printf("查询消息 #%d 失败: %v", id, err)
+		cr.replySystem(msg, "操作失败，请稍后重试")
+		return nil, false
+	}
+	if m == nil || !cr.canSee(m, msg.Sender) {
+		cr.replySystem(msg, fmt.Sprintf("消息 #%d 不存在", id))
+		return nil, false
+	}
+	return m, true
+}
+
+// archivedMessage 把归档的消息转换为查询结果中的一项，内容不带 "[房间] 发送者:" 前缀
+func archivedMessage(m db.ArchivedMessage, reactions []db.Reaction) *Message {
+	message := &Message{
+		Type:      MessageChat,
+		ID:        m.Id,
+		Time:      m.CreatedAt.UnixMilli(),
+		Sender:    m.Sender,
+		Room:      m.Room,
+		Content:   displayContent(m),
+		ParentID:  m.ParentId,
+		Reactions: reactions,
+	}
+	if m.Receiver != "" {
+		message.Type = MessagePrivate
+		message.Receiver = m.Receiver
+	}
+	return message
+}
@@ -1,80 +1,147 @@
 package msg
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
-	"github.com/go-sql-driver/mysql"
-	"io"
 	"log"
-	"net"
 	"onlineChatRoom/db"
 	"onlineChatRoom/utils"
+	"sort"
+	"strings"
 	"time"
 )
 
-// broadcast 广播（仅系统消息与群聊）
-func (cr *ChatRoom) broadcast(sender, content string) {
+// broadcast 房间内广播（仅系统消息与群聊），exclude 不会收到
+// 只是把消息放进各客户端的发送队列，慢客户端不会阻塞其他人
+func (cr *ChatRoom) broadcast(roomName string, exclude string, message *Message) {
+	cr.broadcastExcept(roomName, message, func(username string) bool { return username == exclude })
+}
+
+// broadcastExcept 房间内广播，skip 返回 true 的用户不会收到
+func (cr *ChatRoom) broadcastExcept(roomName string, message *Message, skip func(username string) bool) {
 	cr.Mutex.Lock()
 	defer cr.Mutex.Unlock()
 
-	for username, client := range cr.Clients {
-		if username == sender {
+	room, ok := cr.Rooms[roomName]
+	if !ok {
+		return
+	}
+	for username := range room.Members {
+		client, online := cr.Clients[username]
+		if skip(username) || !online {
 			continue
 		}
-		err := SendJsonMessage(client.Conn, &Message{
-			Type:    MessageChat,
-			Sender:  sender,
-			Content: content,
-		})
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				fmt.Println(sender, "已退出聊天室...连接已关闭")
-				return
-			}
-			log.Println("broadcast:", err)
-			return
+		if err := client.WriteMessage(message); err != nil {
+			log.Printf("broadcast to %s: %v\n", username, err)
 		}
 	}
-	fmt.Println(content)
+	fmt.Println(message.Content)
 }
 
-// PrivateChat 私聊
+// PrivateChat 私聊，只由接收者所在的节点投递
+// 接收者已不在任何节点在线时，由发送者所在的节点转为离线暂存，保证只暂存一次
 func (cr *ChatRoom) PrivateChat(msg *Message) {
 	cr.Mutex.Lock()
-	defer cr.Mutex.Unlock()
 	target, ok := cr.Clients[msg.Receiver]
 	if !ok {
-		_ = SendJsonMessage(msg.Conn, &Message{
-			Type:    MessageChat,
-			Sender:  "[系统]",
-			Content: fmt.Sprintf("用户 %s 不存在或不在线", msg.Receiver),
-		})
+		cr.Mutex.Unlock()
+		// msg.Conn 只在发送者连接在本节点时才有
+		if msg.Conn == nil {
+			return
+		}
+		online, err := cr.presence.IsOnline(msg.Receiver)
+		if err != nil {
+			log.Printf("查询用户 %s 在线状态失败: %v", msg.Receiver, err)
+			return
+		}
+		if !online {
+			if err = cr.storeOffline(msg); err != nil {
+				log.Println("暂存离线私聊失败:", err)
+				cr.replySystem(msg, fmt.Sprintf("发送给 %s 的私聊失败，请稍后重试", msg.Receiver))
+			}
+		}
 		return
 	}
-	err := SendJsonMessage(target.Conn, &Message{
-		Type:    MessagePrivate,
-		Sender:  msg.Sender,
-		Content: msg.Content,
+	err := target.WriteMessage(&Message{
+		Type:     MessagePrivate,
+		ID:       msg.ID,
+		Time:     msg.Time,
+		Sender:   msg.Sender,
+		Content:  msg.Content,
+		ParentID: msg.ParentID,
+		Quote:    msg.Quote,
 	})
+	// 入队后就释放锁，记录送达要访问存储，不能在持锁时进行
+	cr.Mutex.Unlock()
 	if err != nil {
 		log.Println("PrivateChat:", err)
 		return
 	}
+	cr.markDelivered(msg.ID)
 	fmt.Printf("%s 私聊 %s: %s\n", msg.Sender, msg.Receiver, msg.Content)
 }
 
-// ShowClients 查询在线列表
-func (cr *ChatRoom) ShowClients(name string, conn net.Conn) {
-	cr.Mutex.Lock()
-	defer cr.Mutex.Unlock()
+// storeOffline 暂存发给离线用户的私聊
+func (cr *ChatRoom) storeOffline(msg *Message) error {
+	err := cr.messages.AddOffline(msg.ID, msg.Sender, msg.Receiver, msg.Content, time.UnixMilli(msg.Time))
+	if err != nil {
+		return err
+	}
+	cr.replySystem(msg, fmt.Sprintf("用户 %s 不在线，私聊将在其上线后送达", msg.Receiver))
+	fmt.Printf("%s 私聊 %s(离线暂存): %s\n", msg.Sender, msg.Receiver, msg.Content)
+	return nil
+}
+
+// sendOffline 登录后投递离线期间收到的私聊
+func (cr *ChatRoom) sendOffline(username string, conn Conn) {
+	messages, err := cr.messages.ListOffline(username)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+	var lastID int64
+	for _, m := range messages {
+		err = conn.WriteMessage(&Message{
+			Type:    MessagePrivate,
+			ID:      m.MessageId,
+			Time:    m.CreatedAt.UnixMilli(),
+			Sender:  m.Sender,
+			Content: fmt.Sprintf("(离线消息，发送于 %s) %s", m.CreatedAt.Format("01-02 15:04:05"), m.Content),
+		})
+		if err != nil {
+			log.Println("投递离线私聊失败:", err)
+			break
+		}
+		cr.markDelivered(m.MessageId)
+		lastID = m.Id
+	}
+	// 只删除已经成功投递的部分，剩下的下次登录再投递
+	if lastID == 0 {
+		return
+	}
+	err = cr.messages.DeleteOffline(username, lastID)
+	if err != nil {
+		log.Println(err)
+	}
+}
 
+// ShowClients 查询在线列表，包含所有节点的在线用户
+func (cr *ChatRoom) ShowClients(name string, conn Conn) {
+	users, err := cr.presence.Online()
+	if err != nil {
+		log.Println("查询在线用户失败:", err)
+		return
+	}
+	sort.Strings(users)
 	list := "在线用户列表: "
-	for username := range cr.Clients {
+	for _, username := range users {
 		list += username + "  "
 	}
 
-	err := SendJsonMessage(conn, &Message{
+	err = conn.WriteMessage(&Message{
 		Type:    MessageList,
 		Content: list,
 	})
@@ -85,12 +152,23 @@ func (cr *ChatRoom) ShowClients(name string, conn net.Conn) {
 }
 
 // Register 处理注册信息
-func Register(msg *Message) {
-	err := db.AddUserDb(msg.Sender, msg.Content)
+func (cr *ChatRoom) Register(msg *Message) {
+	hash, err := utils.HashPassword(msg.Content)
+	if err != nil {
+		log.Println("注册失败:", err)
+		rr := msg.Conn.WriteMessage(&Message{
+			Type:    MessageRegister,
+			Content: "注册失败，请稍后重试",
+		})
+		if rr != nil {
+			log.Println("Register send error:", rr)
+		}
+		return
+	}
+	err = cr.users.AddUser(msg.Sender, hash)
 	if err != nil {
-		// 检查是否是唯一约束冲突（用户名已存在）
-		if isDuplicateKeyError(err) {
-			rr := SendJsonMessage(msg.Conn, &Message{
+		if errors.Is(err, db.ErrUserExists) {
+			rr := msg.Conn.WriteMessage(&Message{
 				Type:    MessageRegister,
 				Content: "用户名: " + msg.Sender + " 已被注册",
 			})
@@ -99,7 +177,7 @@ func Register(msg *Message) {
 			}
 		} else {
 			log.Println("注册失败:", err)
-			rr := SendJsonMessage(msg.Conn, &Message{
+			rr := msg.Conn.WriteMessage(&Message{
 				Type:    MessageRegister,
 				Content: "注册失败，请稍后重试",
 			})
@@ -110,7 +188,7 @@ func Register(msg *Message) {
 		return
 	}
 	// 注册成功
-	rr := SendJsonMessage(msg.Conn, &Message{
+	rr := msg.Conn.WriteMessage(&Message{
 		Type:    MessageRegister,
 		Content: "OK",
 	})
@@ -120,141 +198,202 @@ func Register(msg *Message) {
 	fmt.Println(msg.Sender, "注册成功...")
 }
 
-// isDuplicateKeyError 辅助函数检查是否是唯一约束错误
-func isDuplicateKeyError(err error) bool {
-	var mysqlErr *mysql.MySQLError
-	if errors.As(err, &mysqlErr) {
-		return mysqlErr.Number == 1062 // 1062是MySQL的重复键问题
-	}
-	return false
-}
-
-// Join 处理登录消息
-func (cr *ChatRoom) Join(msg *Message) bool {
-	password, err := db.SearchUserDb(msg.Sender)
+// Join 处理登录消息，成功返回带发送队列的客户端，失败返回 nil
+func (cr *ChatRoom) Join(msg *Message) *Client {
+	password, err := cr.users.SearchUser(msg.Sender)
 	// 查询失败的情况
 	if err != nil {
 		var respContent string
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, db.ErrUserNotFound) {
 			respContent = fmt.Sprintf("%s 不存在，请先注册", msg.Sender)
 		} else {
 			respContent = "登录失败，数据库异常"
 			log.Printf("查询用户 %s 失败: %v", msg.Sender, err)
 		}
 		// 发送错误响应
-		if r := SendJsonMessage(msg.Conn, &Message{
+		if r := msg.Conn.WriteMessage(&Message{
 			Type:    MessageChat,
 			Content: respContent,
 		}); r != nil {
 			log.Println("发送登录失败响应错误:", err)
 		}
-		return false
+		return nil
 	}
 	// 判断密码
-	if password != msg.Content {
-		if r := SendJsonMessage(msg.Conn, &Message{
+	ok, legacy := utils.CheckPassword(password, msg.Content)
+	if !ok {
+		if r := msg.Conn.WriteMessage(&Message{
 			Type:    MessageChat,
 			Content: "密码错误，请重新输入",
 		}); r != nil {
 			log.Println("发送密码错误响应错误:", err)
 		}
-		return false
+		return nil
 	}
-
-	if _, ok := cr.Clients[msg.Sender]; ok {
-		if r := SendJsonMessage(msg.Conn, &Message{
-			Type:    MessageChat,
-			Content: "该账户已登录",
-		}); r != nil {
-			log.Println("发送账号已登陆响应错误:", err)
+	// 旧的明文密码在首次登录成功时升级为哈希
+	if legacy {
+		if hash, hashErr := utils.HashPassword(msg.Content); hashErr != nil {
+			log.Printf("用户 %s 密码哈希失败: %v", msg.Sender, hashErr)
+		} else if upErr := cr.users.UpdatePassword(msg.Sender, hash); upErr != nil {
+			log.Printf("用户 %s 密码升级失败: %v", msg.Sender, upErr)
 		}
-		return false
 	}
+
+	if !cr.admit(msg) {
+		return nil
+	}
+	session := db.Session{Username: msg.Sender, Rooms: []string{DefaultRoom}, LoginAt: time.Now()}
+	cr.newSession(&session)
 	// 登录成功
-	rr := SendJsonMessage(msg.Conn, &Message{
+	rr := msg.Conn.WriteMessage(&Message{
 		Type:    MessageRegister,
 		Content: "OK",
+		Token:   session.Token,
 	})
 	if rr != nil {
 		log.Println("Register send error:", rr)
-		return false
+		cr.releasePresence(msg.Sender)
+		return nil
 	}
-	client := &Client{Username: msg.Sender, Conn: msg.Conn, LastHeartbeat: time.Now()}
+	client := NewClient(msg.Sender, msg.Conn, cr.cfg.Server.SendQueueSize, cr.cfg.Server.WriteTimeout)
+	client.session = session
 	cr.AddClient(msg.Sender, client)
+	// 之后发给该用户的消息都走发送队列
+	msg.Conn = client
 	//content := fmt.Sprintf("系统广播：%s 加入了聊天室...", msg.Sender)
 	//cr.broadcast(msg.Sender, content)
-	// 发送历史消息
-	historyMsg, rrr := db.ShowHistory()
-	if rrr != nil {
-		log.Println(rrr)
-	}
-	message := &Message{Type: MessageChat, Content: historyMsg}
-	r := SendJsonMessage(msg.Conn, message)
-	if r != nil {
-		log.Println("发送历史消息失败:", r)
-	}
+	// 自动加入默认房间并发送历史消息
+	cr.Mutex.Lock()
+	cr.Rooms[DefaultRoom].Members[msg.Sender] = struct{}{}
+	cr.Mutex.Unlock()
+	cr.sendHistory(DefaultRoom, msg.Conn)
+	cr.sendOffline(msg.Sender, msg.Conn)
+	cr.sendMentions(msg.Sender, msg.Conn)
 	// 加入streams流
-	_, err = db.AddStreamsData("系统广播", fmt.Sprintf("%s 加入了聊天室...", msg.Sender), msg.Sender)
-	if err != nil {
-		log.Println("写入 Redis Streams 失败:", err)
-	}
+	cr.systemNotice(DefaultRoom, msg.Sender, fmt.Sprintf("%s 加入了聊天室...", msg.Sender))
 	// 增加活跃度
-	err = db.AddActivity(msg.Sender, 2)
+	cr.addActivity(msg.Sender, 2)
+	return client
+}
+
+// admit 登录前的检查：未被封禁，且没有在任何节点登录，通过时标记在本节点上线，不通过时直接回复原因
+func (cr *ChatRoom) admit(msg *Message) bool {
+	// 封禁检查失败时放行，不因为存储异常把所有人挡在外面
+	banned, err := cr.sanctions.ActiveSanction(msg.Sender, db.SanctionBan, time.Now())
 	if err != nil {
-		log.Println(msg.Sender, "登录增加活跃度失败 :", err)
+		log.Printf("查询用户 %s 封禁状态失败: %v", msg.Sender, err)
+	}
+	if banned != nil {
+		if r := msg.Conn.WriteMessage(&Message{
+			Type:    MessageChat,
+			Content: "你已被封禁" + sanctionText(banned),
+		}); r != nil {
+			log.Println("发送封禁响应错误:", r)
+		}
+		return false
+	}
+	// 同一账号在整个集群中只能登录一次
+	claimed, err := cr.presence.Claim(msg.Sender, cr.nodeID(), cr.presenceTTL())
+	if err != nil || !claimed {
+		respContent := "该账户已登录"
+		if err != nil {
+			respContent = "登录失败，请稍后重试"
+			log.Printf("标记用户 %s 在线失败: %v", msg.Sender, err)
+		}
+		if r := msg.Conn.WriteMessage(&Message{
+			Type:    MessageChat,
+			Content: respContent,
+		}); r != nil {
+			log.Println("发送账号已登陆响应错误:", r)
+		}
+		return false
 	}
 	return true
 }
 
-// Leave 处理退出消息
-func (cr *ChatRoom) Leave(username string) {
-	_, err := db.AddStreamsData("系统广播", fmt.Sprintf("%s 离开了聊天室...", username), username)
-	if err != nil {
-		log.Println("Leave写入 Redis Streams 失败:", err)
+// Leave 处理断开连接，关闭连接并通知其所在的房间，重复调用只生效一次
+// 会话保留下来，客户端可以凭令牌恢复并回到这些房间
+func (cr *ChatRoom) Leave(client *Client) {
+	rooms, removed := cr.RemoveClient(client)
+	if !removed {
+		return
+	}
+	_ = client.Close()
+	cr.releasePresence(client.Username)
+	cr.keepSession(client, rooms)
+	for _, roomName := range rooms {
+		cr.systemNotice(roomName, client.Username, fmt.Sprintf("%s 离开了聊天室...", client.Username))
+	}
+}
+
+// releasePresence 标记用户在本节点下线
+func (cr *ChatRoom) releasePresence(username string) {
+	if err := cr.presence.Release(username, cr.nodeID()); err != nil {
+		log.Printf("标记用户 %s 下线失败: %v", username, err)
 	}
-	cr.RemoveClient(username)
 }
 
 // PongHeart 处理心跳
 func (cr *ChatRoom) PongHeart(username string) {
 	cr.Mutex.Lock()
-	defer cr.Mutex.Unlock()
-	if client, exists := cr.Clients[username]; exists {
+	client, exists := cr.Clients[username]
+	if exists {
 		client.LastHeartbeat = time.Now()
-		err := client.Conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		err := client.Conn.SetReadDeadline(time.Now().Add(cr.cfg.Heartbeat.ReadDeadline))
 		if err != nil {
 			log.Printf("PongHeart: %v", err)
 		}
 	}
+	cr.Mutex.Unlock()
+	if !exists {
+		return
+	}
+	// 续期在线状态，不在持锁时访问存储
+	if err := cr.presence.Refresh(username, cr.nodeID(), cr.presenceTTL()); err != nil {
+		log.Printf("用户 %s 在线状态续期失败: %v", username, err)
+	}
 }
 
 // StartHeartbeatMonitor 服务端定期检测客户端心跳超时
 func (cr *ChatRoom) StartHeartbeatMonitor() {
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(cr.cfg.Heartbeat.CheckInterval)
 	defer ticker.Stop()
 	for {
 		<-ticker.C
 		now := time.Now()
+		var timeout []*Client
 		cr.Mutex.Lock()
-		for username, client := range cr.Clients {
-			if now.Sub(client.LastHeartbeat) > 20*time.Second {
-				log.Printf("用户 %s 心跳超时，强制下线\n", username)
-				utils.CloseConn(client.Conn, username)
-				cr.Leave(username)
+		for _, client := range cr.Clients {
+			if now.Sub(client.LastHeartbeat) > cr.cfg.Heartbeat.Timeout {
+				timeout = append(timeout, client)
 			}
 		}
 		cr.Mutex.Unlock()
+		// Leave 内部会加锁，需在释放锁之后调用
+		for _, client := range timeout {
+			log.Printf("用户 %s 心跳超时，强制下线\n", client.Username)
+			cr.Leave(client)
+		}
 	}
 }
 
 // SendRank 发送活跃度排行
-func SendRank(username string, conn net.Conn) {
-	sprintf, err := db.ShowActivityRank()
+func (cr *ChatRoom) SendRank(username string, conn Conn) {
+	items, err := cr.rank.ActivityRank()
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	rr := SendJsonMessage(conn, &Message{Type: MessageRank, Content: sprintf})
+	var sprintf string
+	for i, item := range items {
+		if item.Username == "系统广播" {
+			continue
+		}
+		// 显示排名、名字和分数、排名从 1 开始
+		sprintf = fmt.Sprintf("%s排名 %d: %s\t, 活跃度=%d\n", sprintf, i+1, item.Username, int(item.Score))
+	}
+	sprintf = strings.Trim(sprintf, "\n")
+	rr := conn.WriteMessage(&Message{Type: MessageRank, Content: sprintf})
 	if rr != nil {
 		log.Printf("向%s发送活跃度排名失败:%s", username, rr)
 		return
@@ -0,0 +1,92 @@
+package msg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"onlineChatRoom/db"
+	"time"
+)
+
+// 正在输入事件的 Content
+const (
+	TypingStart = "start" // 正在输入，客户端输入期间每隔 TypingInterval 发一次
+	TypingStop  = "stop"  // 停止输入
+)
+
+// TypingInterval 客户端持续输入时重复发送正在输入的间隔，超过 TypingTimeout 没再收到就当作已停止
+const (
+	TypingInterval = 3 * time.Second
+	TypingTimeout  = 2 * TypingInterval
+)
+
+// eventTyping 正在输入的即时事件
+const eventTyping = "typing"
+
+// Typing 把正在输入和停止输入转发给私聊对方或房间里的其他成员
+// 这类消息丢了也无所谓，不写入streams流，通过即时事件发给所有节点；超过频率的直接丢弃，不警告也不处罚
+// 私聊对方不存在或不在该房间中时和发消息一样回复 nack
+func (cr *ChatRoom) Typing(msg *Message) {
+	if msg.Content != TypingStart && msg.Content != TypingStop {
+		return
+	}
+	if msg.Content == TypingStart {
+		if ok, _ := cr.typing.Allow(msg.Sender, time.Now()); !ok {
+			return
+		}
+	}
+	event := db.Event{Action: eventTyping, Sender: msg.Sender, Receiver: msg.Receiver, Content: msg.Content}
+	if msg.Receiver == "" {
+		event.Room = DefaultRoom
+		if msg.Room != "" {
+			event.Room = msg.Room
+		}
+		// 只有房间的成员能在房间里显示正在输入
+		if !cr.isMember(event.Room, msg.Sender) {
+			Nack(msg, &SendError{Code: CodeNotInRoom, Reason: fmt.Sprintf("你不在房间 %s 中，请先 join %s", event.Room, event.Room)})
+			return
+		}
+	} else {
+		if msg.Receiver == msg.Sender {
+			return
+		}
+		if _, err := cr.users.SearchUser(msg.Receiver); err != nil {
+			if errors.Is(err, db.ErrUserNotFound) {
+				err = &SendError{Code: CodeNoUser, Reason: fmt.Sprintf("用户 %s 不存在", msg.Receiver)}
+			}
+			Nack(msg, err)
+			return
+		}
+	}
+	if err := cr.events.PublishEvent(event); err != nil {
+		log.Println("发布正在输入事件失败:", err)
+	}
+}
+
+// deliverTyping 把正在输入事件投递给连接在本节点的私聊对方或房间成员
+func (cr *ChatRoom) deliverTyping(event db.Event) {
+	message := &Message{Type: MessageTyping, Sender: event.Sender, Room: event.Room, Content: event.Content}
+	cr.Mutex.Lock()
+	defer cr.Mutex.Unlock()
+	if event.Receiver != "" {
+		if client, ok := cr.Clients[event.Receiver]; ok {
+			if err := client.WriteMessage(message); err != nil {
+				log.Println("Typing:", err)
+			}
+		}
+		return
+	}
+	room, ok := cr.Rooms[event.Room]
+	if !ok {
+		return
+	}
+	for username := range room.Members {
+		client, online := cr.Clients[username]
+		if username == event.Sender || !online {
+			continue
+		}
+		if err := client.WriteMessage(message); err != nil {
+			log.Println("Typing:", err)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"onlineChatRoom/config"
 	"onlineChatRoom/db"
 	"onlineChatRoom/msg"
 	"onlineChatRoom/server/tool"
+	"os"
 )
 
 func main() {
@@ -14,32 +18,35 @@ func main() {
 		if err := recover(); err != nil {
 			log.Printf("server main panic recovered: %v\n", err)
 		}
-		rr := db.DB.Close()
-		if rr != nil {
-			log.Println("MySQL连接关闭失败..")
+		if db.DB != nil {
+			if rr := db.DB.Close(); rr != nil {
+				log.Println("MySQL连接关闭失败..")
+			}
 		}
-		r := db.RDB.Close()
-		if r != nil {
-			log.Println("Redis连接关闭失败..")
+		if db.RDB != nil {
+			if r := db.RDB.Close(); r != nil {
+				log.Println("Redis连接关闭失败..")
+			}
 		}
 	}()
-	room := msg.NewChatRoom()
-	// 连接MySQL
-	dbErr := db.ConnectDb()
-	if dbErr != nil {
-		log.Fatal(dbErr)
+	// 加载配置
+	cfg, cfgErr := config.Load(os.Args[1:])
+	if cfgErr != nil {
+		log.Fatal(cfgErr)
 	}
-	// 连接Redis
-	RedisErr := db.InitRedis()
-	if RedisErr != nil {
-		log.Fatal(RedisErr)
+	stores, storeErr := openStores(cfg)
+	if storeErr != nil {
+		log.Fatal(storeErr)
 	}
-	// 清理Redis数据
-	db.ClearRedis()
+	room := msg.NewChatRoom(cfg, stores)
 	go room.HandleStreams()
 	go room.HandleChanMessages()
+	go room.HandleEvents()
 	go room.StartHeartbeatMonitor()
-	listener, err := net.Listen("tcp", ":8080")
+	if cfg.Server.WebSocket.Addr != "" {
+		go serveWebSocket(cfg.Server, room)
+	}
+	listener, err := listen(cfg.Server)
 	if err != nil {
 		log.Fatal("server start failed:", err)
 	}
@@ -57,6 +64,64 @@ func main() {
 			continue
 		}
 		//处理客户端
-		go tool.HandleClientMessage(conn, room)
+		go tool.HandleClientMessage(msg.NewTCPConn(conn), room)
+	}
+}
+
+// openStores 按配置创建存储，mysql 模式会连接 MySQL 和 Redis
+func openStores(cfg *config.Config) (*db.Stores, error) {
+	if cfg.Server.Storage == config.StorageMemory {
+		fmt.Println("使用内存存储，重启后数据丢失")
+		return db.NewMemoryStores(cfg.Redis.StreamMaxLen), nil
+	}
+	// 连接MySQL
+	if err := db.ConnectDb(cfg.MySQL); err != nil {
+		return nil, err
+	}
+	// 调整表结构
+	if err := db.MigrateDb(); err != nil {
+		return nil, err
+	}
+	// 连接Redis
+	if err := db.InitRedis(cfg.Redis); err != nil {
+		return nil, err
+	}
+	// 清理Redis数据，集群中其他节点还在使用，不能清理
+	if cfg.Cluster.Enabled {
+		fmt.Println("集群模式，节点ID:", cfg.Cluster.NodeID)
+	} else {
+		db.ClearRedis()
+	}
+	return db.NewStores(cfg.Redis.StreamMaxLen), nil
+}
+
+// listen 按配置监听 TCP，开启 TLS 时使用 TLS 监听
+func listen(cfg config.Server) (net.Listener, error) {
+	if !cfg.TLS.Enabled {
+		return net.Listen("tcp", cfg.Addr)
+	}
+	tlsCfg, err := cfg.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", cfg.Addr, tlsCfg)
+}
+
+// serveWebSocket 启动 WebSocket 网关，开启 TLS 时使用同一套证书(wss)
+func serveWebSocket(cfg config.Server, room *msg.ChatRoom) {
+	mux := http.NewServeMux()
+	mux.Handle(cfg.WebSocket.Path, tool.NewWebSocketHandler(room, cfg.WebSocket.AllowedOrigins))
+	srv := &http.Server{Addr: cfg.WebSocket.Addr, Handler: mux}
+	fmt.Println("WebSocket 网关已启动:", cfg.WebSocket.Addr+cfg.WebSocket.Path)
+	var err error
+	if cfg.TLS.Enabled {
+		srv.TLSConfig, err = cfg.TLS.Config()
+		if err != nil {
+			log.Fatal("websocket start failed:", err)
+		}
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
 	}
+	log.Fatal("websocket start failed:", err)
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"onlineChatRoom/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert 测试用的证书和私钥
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert 生成证书，parent 为 nil 时生成自签名的 CA
+func newTestCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write 把证书和私钥写成 PEM 文件，返回两个文件的路径
+func (c *testCert) write(t *testing.T, dir string, name string) (certFile string, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// TestListenTLS 开启双向认证的 TLS 监听，只有持有同一 CA 签发的客户端证书才能通信
+func TestListenTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test ca", nil, 0)
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := newTestCert(t, "server", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	clientCert, clientKey := newTestCert(t, "client", ca, x509.ExtKeyUsageClientAuth).write(t, dir, "client")
+	otherCA := newTestCert(t, "other ca", nil, 0)
+	otherCert, otherKey := newTestCert(t, "other", otherCA, x509.ExtKeyUsageClientAuth).write(t, dir, "other")
+
+	listener, err := listen(config.Server{
+		Addr: "127.0.0.1:0",
+		TLS: config.ServerTLS{
+			Enabled:      true,
+			CertFile:     serverCert,
+			KeyFile:      serverKey,
+			ClientCAFile: caFile,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// 握手通过后回一个字节，握手失败时直接断开
+			go func() {
+				defer conn.Close()
+				if err := conn.(*tls.Conn).Handshake(); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte{'1'})
+			}()
+		}
+	}()
+
+	tests := []struct {
+		name string
+		tls  config.ClientTLS
+		ok   bool
+	}{
+		{"客户端证书", config.ClientTLS{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey}, true},
+		{"没有客户端证书", config.ClientTLS{CAFile: caFile}, false},
+		{"其他 CA 签发的客户端证书", config.ClientTLS{CAFile: caFile, CertFile: otherCert, KeyFile: otherKey}, false},
+		{"不信任服务端的 CA", config.ClientTLS{CertFile: clientCert, KeyFile: clientKey}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.tls.Enabled = true
+			tlsCfg, err := tt.tls.Config()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// TLS 1.3 下服务端拒绝客户端证书要到第一次读时才能发现
+			conn, err := tls.Dial("tcp", listener.Addr().String(), tlsCfg)
+			if err == nil {
+				defer conn.Close()
+				_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, err = io.ReadFull(conn, make([]byte, 1))
+			}
+			if tt.ok && err != nil {
+				t.Fatalf("应该连接成功: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("应该连接失败")
+			}
+		})
+	}
+}
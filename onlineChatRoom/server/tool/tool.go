@@ -1,49 +1,46 @@
 package tool
 
 import (
-	"bufio"
 	"errors"
 	"io"
 	"log"
-	"net"
-	"onlineChatRoom/db"
 	"onlineChatRoom/msg"
 )
 
-// HandleClientMessage 处理客户端
-func HandleClientMessage(conn net.Conn, room *msg.ChatRoom) {
+// HandleClientMessage 处理客户端，TCP 和 WebSocket 连接共用
+func HandleClientMessage(conn msg.Conn, room *msg.ChatRoom) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("server handleClientMessage panic recovered: %v\n", r)
 		}
 	}()
-	reader := bufio.NewReader(conn)
-
-	username := handleRegisterOrLogin(reader, conn, room)
-	if username == "" {
+	client := handleRegisterOrLogin(conn, room)
+	if client == nil {
 		return
 	}
-	handleCommonMsg(username, reader, conn, room)
+	handleCommonMsg(client, room)
 }
 
 // handleRegisterOrLogin 处理登录注册的消息
-func handleRegisterOrLogin(reader *bufio.Reader, conn net.Conn, room *msg.ChatRoom) (username string) {
+func handleRegisterOrLogin(conn msg.Conn, room *msg.ChatRoom) *msg.Client {
 	for {
-		initMsg, err := msg.ReadJsonMessage(reader)
+		initMsg, err := conn.ReadMessage()
 		if err != nil {
 			log.Printf("%s 在登录注册时失败", conn.RemoteAddr().String())
-			return ""
+			return nil
 		}
 		initMsg.Conn = conn
 		switch initMsg.Type {
 		case msg.MessageRegister:
-			msg.Register(initMsg)
+			room.Register(initMsg)
 			continue
 		case msg.MessageJoin:
-			status := room.Join(initMsg)
-			if status {
-				username = initMsg.Sender
-				return username
+			if client := room.Join(initMsg); client != nil {
+				return client
+			}
+		case msg.MessageResume:
+			if client := room.Resume(initMsg); client != nil {
+				return client
 			}
 		default:
 		}
@@ -52,28 +49,47 @@ func handleRegisterOrLogin(reader *bufio.Reader, conn net.Conn, room *msg.ChatRo
 }
 
 // handleCommonMsg 处理登录注册之后的信息
-func handleCommonMsg(username string, reader *bufio.Reader, conn net.Conn, room *msg.ChatRoom) {
+func handleCommonMsg(client *msg.Client, room *msg.ChatRoom) {
 	for {
-		message, err := msg.ReadJsonMessage(reader)
+		message, err := client.ReadMessage()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				//log.Printf("正常退出")
 			} else {
-				log.Printf("%s 被kill或者异常断开...\n", username)
-				room.Leave(username)
+				log.Printf("%s 被kill或者异常断开...\n", client.Username)
 			}
+			// 已经 quit 或被踢下线时 Leave 不会重复生效
+			room.Leave(client)
 			return
 		}
-		message.Conn = conn
+		// 回复都走该客户端的发送队列
+		message.Conn = client
+		message.Sender = client.Username
+		if room.Throttle(client, message) {
+			continue
+		}
 		switch message.Type {
-		case msg.MessageLeave, msg.MessageList, msg.MessageRank, msg.MessageHeart:
+		case msg.MessageLeave, msg.MessageList, msg.MessageRank, msg.MessageHeart, msg.MessageTyping,
+			msg.MessageCreateRoom, msg.MessageJoinRoom, msg.MessageLeaveRoom, msg.MessageListRooms,
+			msg.MessageKick, msg.MessageMute, msg.MessageBan, msg.MessageUnmute, msg.MessageUnban,
+			msg.MessageEdit, msg.MessageRecall, msg.MessageDelete, msg.MessageReact, msg.MessageUnreact,
+			msg.MessageRead:
 			room.MsgChan <- message
-		default:
-			// 聊天消息才异步入 Redis Streams
-			_, err = db.AddStreamsData(message.Sender, message.Content, message.Receiver)
-			if err != nil {
-				log.Println("写入 Redis Streams 失败:", err)
+		case msg.MessageThread, msg.MessageReceipts, msg.MessageSearch, msg.MessageHistory:
+			room.HandleQuery(message)
+		case msg.MessageFileUpload, msg.MessageFileChunk, msg.MessageFileDownload:
+			room.HandleFile(message)
+		case msg.MessageChat, msg.MessagePrivate:
+			// 聊天消息才异步入 Redis Streams，结果通过 ack/nack 告知发送者
+			id, sentAt, publishErr := room.Publish(message)
+			if publishErr != nil {
+				msg.Nack(message, publishErr)
+				continue
 			}
+			msg.Ack(message, id, sentAt)
+		default:
+			// 登录后不该出现的类型(如 join、register)和未知类型一律拒绝，不当作聊天绕过限流
+			msg.Nack(message, &msg.SendError{Code: msg.CodeBadType, Reason: "不支持的消息类型"})
 		}
 	}
 }
@@ -0,0 +1,44 @@
+package tool
+
+import (
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"onlineChatRoom/msg"
+)
+
+// NewWebSocketHandler 把 WebSocket 连接接入同一个聊天室，帧内容与 TCP 一样是 JSON 格式的 msg.Message
+// allowedOrigins 为空时只允许同源页面连接，包含 "*" 时允许任意来源
+func NewWebSocketHandler(room *msg.ChatRoom, allowedOrigins []string) http.Handler {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: checkOrigin(allowedOrigins),
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Println(r.RemoteAddr, " websocket upgrade failed:", err)
+			return
+		}
+		conn := msg.NewWSConn(ws)
+		defer func() {
+			_ = conn.Close()
+		}()
+		HandleClientMessage(conn, room)
+	})
+}
+
+// checkOrigin 校验浏览器的 Origin 请求头
+func checkOrigin(allowedOrigins []string) func(r *http.Request) bool {
+	if len(allowedOrigins) == 0 {
+		return nil // 使用 gorilla/websocket 默认的同源校验
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || allowed == origin {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"strings"
+)
+
+// HashPassword 使用 bcrypt 生成加盐哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt.GenerateFromPassword failed:%w", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword 校验密码，stored 可能是 bcrypt 哈希，也可能是旧版明文
+// legacy 为 true 表示库中仍是明文，调用方应在校验通过后重新哈希
+func CheckPassword(stored string, password string) (ok bool, legacy bool) {
+	if !isBcryptHash(stored) {
+		// 旧数据是明文，用常量时间比较避免时序泄露
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, true
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password))
+	if err != nil {
+		if !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			log.Println("CompareHashAndPassword:", err)
+		}
+		return false, false
+	}
+	return true, false
+}
+
+// isBcryptHash 判断是否为 bcrypt 哈希格式
+func isBcryptHash(s string) bool {
+	return len(s) == 60 && (strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$"))
+}
+
+// NewToken 生成随机的会话令牌
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("rand.Read failed:%w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
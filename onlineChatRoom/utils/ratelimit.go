@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval 清理空闲令牌桶的间隔
+const sweepInterval = time.Minute
+
+// Limiter 按 key 区分的令牌桶限流器，并发安全
+// 每隔 every 恢复一个令牌，最多存 burst 个；every 为 0 时不限流
+type Limiter struct {
+	mu        sync.Mutex
+	every     time.Duration
+	burst     int
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket 令牌桶
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(every time.Duration, burst int) *Limiter {
+	return &Limiter{
+		every:     every,
+		burst:     burst,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow 消耗 key 的一个令牌，没有令牌时返回 false 和还需等待的时长
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	if l == nil || l.every <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(float64(l.burst), b.tokens+float64(now.Sub(b.last))/float64(l.every))
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) * float64(l.every))
+}
+
+// sweep 删除已经恢复满的令牌桶，调用方需持有锁
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	full := l.every * time.Duration(l.burst)
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
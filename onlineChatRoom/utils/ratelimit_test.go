@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name     string
+		every    time.Duration
+		burst    int
+		at       []time.Duration // 每次请求距 start 的时间
+		want     []bool
+		lastWait time.Duration // 最后一次请求被拒绝时应等待的时长
+	}{
+		{"不限流", 0, 0, []time.Duration{0, 0, 0}, []bool{true, true, true}, 0},
+		{"用完额度", time.Second, 2, []time.Duration{0, 0, 0}, []bool{true, true, false}, time.Second},
+		{"按时恢复", time.Second, 1, []time.Duration{0, time.Second}, []bool{true, true}, 0},
+		{"恢复一半", time.Second, 1, []time.Duration{0, 500 * time.Millisecond}, []bool{true, false}, 500 * time.Millisecond},
+		{"恢复不超过上限", time.Second, 2, []time.Duration{0, 0, time.Hour, time.Hour, time.Hour}, []bool{true, true, true, true, false}, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.every, tt.burst)
+			var wait time.Duration
+			for i, at := range tt.at {
+				var ok bool
+				ok, wait = l.Allow("alice", start.Add(at))
+				if ok != tt.want[i] {
+					t.Fatalf("第 %d 次请求 = %v，应为 %v", i+1, ok, tt.want[i])
+				}
+			}
+			if wait != tt.lastWait {
+				t.Fatalf("等待时长 = %v，应为 %v", wait, tt.lastWait)
+			}
+		})
+	}
+}
+
+func TestLimiterKeys(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(time.Second, 1)
+	if ok, _ := l.Allow("alice", now); !ok {
+		t.Fatal("alice 第一次请求应放行")
+	}
+	if ok, _ := l.Allow("bob", now); !ok {
+		t.Fatal("不同的 key 各自限流")
+	}
+	if ok, _ := l.Allow("alice", now); ok {
+		t.Fatal("alice 的额度已用完")
+	}
+}
@@ -9,20 +9,19 @@ import (
 	"net"
 )
 
-const maxMessageLength = 1 << 20 // 1MB，最大消息长度限制
+const MaxMessageLength = 1 << 20 // 1MB，最大消息长度限制
 
 // SendMessage 向连接发送消息
 func SendMessage(conn net.Conn, message []byte) error {
 	length := uint32(len(message)) // 消息长度
-	if length > maxMessageLength {
+	if length > MaxMessageLength {
 		//log.Println("消息长度超出限制: ", length)
 		return fmt.Errorf("message too long")
 	}
-	err := binary.Write(conn, binary.BigEndian, length) //将数据以二进制写入conn
-	if err != nil {
-		return fmt.Errorf("binary.Write failed")
-	}
-	_, err = conn.Write(message)
+	// 长度和内容一次写入，多个协程同时发送时不会交错
+	buf := make([]byte, 4, 4+len(message))
+	binary.BigEndian.PutUint32(buf, length)
+	_, err := conn.Write(append(buf, message...))
 	if err != nil {
 		return fmt.Errorf("conn.Write failed")
 	}
@@ -44,7 +43,8 @@ func ReadMessage(reader *bufio.Reader) ([]byte, error) {
 	return buf, nil
 }
 
-func CloseConn(conn net.Conn, name string) {
+// CloseConn 关闭连接，失败只记录日志
+func CloseConn(conn io.Closer, name string) {
 	err := conn.Close()
 	if err != nil {
 		log.Printf("%s close conn failed:%s \n", name, err)